@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bufio"
+	"chrelyonly-localsend-go/model"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// TransferRequest 一次待决定的文件传输请求
+// Download 为 true 时是下载模式的访问请求：Sender 为请求下载的一方，Files 为本机共享的文件，
+// Decision.FileIds 限定对方可以下载的文件。
+type TransferRequest struct {
+	SessionId  string
+	Sender     model.RegisterDto        // 发送方设备信息
+	RemoteAddr string                   // 发送方地址 (ip:port)
+	Files      map[string]model.FileDto // 发送方提供的文件，key 为 fileId
+	Download   bool                     // 对方请求下载本机共享的文件
+	Text       string                   // 文本消息的内容，非空时为文本消息 (Files 中只有一个 text/plain 文件)
+}
+
+// Decision 接收方的决定
+type Decision struct {
+	Accept  bool
+	FileIds []string // 只接受其中的部分文件；为 nil 时接受全部
+	Dir     string   // 保存目录，为空时使用默认下载目录；相对路径基于默认下载目录
+}
+
+// AcceptHandler 决定是否接受一次传输请求
+// 实现可以阻塞等待用户操作，但必须在 ctx 结束（超时或发送方断开）时尽快返回。
+type AcceptHandler interface {
+	Decide(ctx context.Context, req TransferRequest) (Decision, error)
+}
+
+// AcceptHandlerFunc 让普通函数实现 AcceptHandler
+type AcceptHandlerFunc func(ctx context.Context, req TransferRequest) (Decision, error)
+
+func (f AcceptHandlerFunc) Decide(ctx context.Context, req TransferRequest) (Decision, error) {
+	return f(ctx, req)
+}
+
+// AutoAccept 自动接受所有请求
+var AutoAccept = AcceptHandlerFunc(func(ctx context.Context, req TransferRequest) (Decision, error) {
+	return Decision{Accept: true}, nil
+})
+
+// PromptAccept 在终端中询问用户是否接受
+// 多个请求同时到达时逐个询问；输入 y 接受全部，n 拒绝，或输入文件序号 (如 1,3) 只接受部分文件。
+type PromptAccept struct {
+	mu    sync.Mutex // 同一时间只允许一个提问
+	out   io.Writer
+	lines chan string
+}
+
+// NewPromptAccept 创建终端交互式确认器
+// 由一个常驻 goroutine 读取 in，提问超时后不会留下悬空的读取操作
+func NewPromptAccept(in io.Reader, out io.Writer) *PromptAccept {
+	p := &PromptAccept{
+		out:   out,
+		lines: make(chan string),
+	}
+	go func() {
+		scanner := bufio.NewScanner(in)
+		for scanner.Scan() {
+			p.lines <- strings.TrimSpace(scanner.Text())
+		}
+		close(p.lines)
+	}()
+	return p
+}
+
+func (p *PromptAccept) Decide(ctx context.Context, req TransferRequest) (Decision, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.drain()
+
+	// 按文件名排序，序号才稳定
+	ids := make([]string, 0, len(req.Files))
+	for id := range req.Files {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return req.Files[ids[i]].FileName < req.Files[ids[j]].FileName
+	})
+
+	switch {
+	case req.Text != "":
+		fmt.Fprintf(p.out, "\n[确认] %s (%s) 想要发送一条消息:\n  %s\n", req.Sender.Alias, req.RemoteAddr, textPreview(req.Text))
+	default:
+		verb := "发送"
+		if req.Download {
+			verb = "下载"
+		}
+		fmt.Fprintf(p.out, "\n[确认] %s (%s) 想要%s %d 个文件:\n", req.Sender.Alias, req.RemoteAddr, verb, len(ids))
+		for i, id := range ids {
+			f := req.Files[id]
+			fmt.Fprintf(p.out, "  %d. %s (%d 字节)\n", i+1, f.FileName, f.Size)
+		}
+	}
+
+	for {
+		fmt.Fprint(p.out, "[确认] 是否接受? [y/n/序号,如 1,3]: ")
+		select {
+		case <-ctx.Done():
+			fmt.Fprintln(p.out, "\n[确认] 等待超时，已拒绝")
+			return Decision{}, ctx.Err()
+		case line, ok := <-p.lines:
+			if !ok {
+				return Decision{}, fmt.Errorf("标准输入已关闭")
+			}
+			decision, err := parseDecision(line, ids)
+			if err != nil {
+				fmt.Fprintf(p.out, "[确认] %v\n", err)
+				continue
+			}
+			return decision, nil
+		}
+	}
+}
+
+// drain 丢弃提问之前残留的输入，避免误当作本次的回答
+func (p *PromptAccept) drain() {
+	for {
+		select {
+		case _, ok := <-p.lines:
+			if !ok {
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+// parseDecision 解析用户输入
+func parseDecision(line string, ids []string) (Decision, error) {
+	switch strings.ToLower(line) {
+	case "y", "yes":
+		return Decision{Accept: true}, nil
+	case "n", "no", "":
+		return Decision{Accept: false}, nil
+	}
+
+	var selected []string
+	seen := make(map[int]bool)
+	for _, part := range strings.Split(line, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || n < 1 || n > len(ids) {
+			return Decision{}, fmt.Errorf("无效输入 %q，请输入 y、n 或 1-%d 之间的序号", line, len(ids))
+		}
+		if !seen[n] {
+			seen[n] = true
+			selected = append(selected, ids[n-1])
+		}
+	}
+	return Decision{Accept: true, FileIds: selected}, nil
+}
@@ -0,0 +1,462 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+	"time"
+)
+
+// deviceOptions 需要设备身份的命令共用的选项
+type deviceOptions struct {
+	port  *int
+	alias *string
+	state *string
+}
+
+// addDeviceFlags 注册端口、别名和状态目录选项
+func addDeviceFlags(fs *flag.FlagSet) *deviceOptions {
+	return &deviceOptions{
+		port:  fs.Int("port", config.Port, "监听端口"),
+		alias: fs.String("alias", config.Alias, "设备别名 (指定后会保存到设备身份中)"),
+		state: fs.String("state", config.StateDir, "状态目录，保存设备指纹、别名和 TLS 密钥"),
+	}
+}
+
+// loadIdentity 加载设备身份，指定了 -alias 时更新别名
+// 指纹持久化在状态目录中，保证重启后对端仍能识别出同一台设备
+func (o *deviceOptions) loadIdentity() (*Identity, error) {
+	identity, err := LoadOrCreateIdentity(*o.state)
+	if err != nil {
+		return nil, fmt.Errorf("加载设备身份失败: %v", err)
+	}
+	if *o.alias != "" {
+		if err := identity.SetAlias(*o.alias); err != nil {
+			return nil, err
+		}
+	}
+	return identity, nil
+}
+
+// printBanner 打印本机信息
+func printBanner(identity *Identity, port int, mode string) {
+	fmt.Println("------------------------------------------------")
+	fmt.Printf("strawberryShare 协议版本 v%s\n", ProtocolVersion)
+	fmt.Printf("别名:        %s\n", identity.Alias)
+	fmt.Printf("指纹:        %s\n", identity.Fingerprint)
+	fmt.Printf("端口:        %d\n", port)
+	fmt.Printf("模式:        %s\n", mode)
+	fmt.Println("------------------------------------------------")
+}
+
+// startDiscovery 启动 UDP 多播监听和设备表清理
+// logEvents 为 true 时打印设备上下线事件
+func startDiscovery(identity *Identity, port int, logEvents bool) *MulticastService {
+	discovery := NewMulticastService(identity.Alias, identity.Fingerprint, DefaultDeviceModel, port)
+	go discovery.StartListener()
+	go discovery.Peers().StartReaper(PeerReapInterval)
+	if logEvents {
+		go logPeerEvents(discovery.Peers().Subscribe())
+	}
+	return discovery
+}
+
+// receiveOptions receive 和 share 命令共用的接收选项
+type receiveOptions struct {
+	accept         *string
+	policy         *string
+	acceptTimeout  *time.Duration
+	maxSessions    *int
+	conflict       *string
+	maxFileSize    *string
+	maxSessionSize *string
+	quarantine     *bool
+	downloadDir    *string
+	pin            *string
+	textSink       *string
+	progress       *bool
+}
+
+// addReceiveFlags 注册接收选项
+func addReceiveFlags(fs *flag.FlagSet) *receiveOptions {
+	return &receiveOptions{
+		accept:         fs.String("accept", "auto", "确认方式 (也用于确认下载请求): auto (自动接受)、prompt (终端询问) 或 policy (按策略文件)"),
+		policy:         fs.String("policy", "", "自动接收策略文件 (JSON)，配合 -accept policy 使用"),
+		acceptTimeout:  fs.Duration("accept-timeout", DefaultAcceptTimeout, "等待确认的最长时间，超时视为拒绝"),
+		maxSessions:    fs.Int("max-sessions", DefaultMaxSessions, "同时进行的接收会话上限: 1 为单会话 (忙时返回 409)，0 为不限制"),
+		conflict:       fs.String("conflict", string(ConflictRename), "文件重名时的处理方式: rename (追加序号)、overwrite (覆盖)、skip (跳过) 或 timestamp (追加时间戳)"),
+		maxFileSize:    fs.String("max-file-size", "", "单个文件的大小上限，如 4G，留空表示不限制"),
+		maxSessionSize: fs.String("max-session-size", "", "单次会话的总大小上限，如 20G，留空表示不限制"),
+		quarantine:     fs.Bool("quarantine", false, "哈希校验失败的文件移入隔离目录，而不是直接删除"),
+		downloadDir:    fs.String("download-dir", config.DownloadDir, "接收文件的保存目录"),
+		pin:            fs.String("pin", "", "要求对方提供的 PIN，留空表示不需要"),
+		textSink:       fs.String("text-sink", "stdout", "文本消息的处理方式: stdout、file:路径 (追加到文件) 或 hook:命令 (内容从标准输入传入)"),
+		progress:       fs.Bool("progress", true, "显示传输进度 (终端中为进度条，否则定期输出日志)"),
+	}
+}
+
+// newServer 按接收选项创建服务端，选项无效时返回 usageError
+func (o *receiveOptions) newServer(identity *Identity, port int) (*FileServer, error) {
+	if *o.maxSessions < 0 {
+		return nil, usageError{"-max-sessions 不能为负数"}
+	}
+	strategy, err := ParseConflictStrategy(*o.conflict)
+	if err != nil {
+		return nil, usageError{err.Error()}
+	}
+	fileLimit, err := ParseSize(*o.maxFileSize)
+	if err != nil {
+		return nil, usageError{"-max-file-size: " + err.Error()}
+	}
+	sessionLimit, err := ParseSize(*o.maxSessionSize)
+	if err != nil {
+		return nil, usageError{"-max-session-size: " + err.Error()}
+	}
+	if *o.downloadDir == "" {
+		return nil, usageError{"-download-dir 不能为空"}
+	}
+	sink, err := ParseTextSink(*o.textSink)
+	if err != nil {
+		return nil, usageError{"-text-sink: " + err.Error()}
+	}
+
+	var acceptor AcceptHandler
+	switch *o.accept {
+	case "auto":
+		acceptor = AutoAccept
+	case "prompt":
+		acceptor = NewPromptAccept(os.Stdin, os.Stdout)
+	case "policy":
+		if *o.policy == "" {
+			return nil, usageError{"-accept policy 需要通过 -policy 指定策略文件"}
+		}
+		policy, err := LoadPolicy(*o.policy)
+		if err != nil {
+			return nil, err
+		}
+		acceptor = policy
+	default:
+		return nil, usageError{fmt.Sprintf("无效的确认方式 %q，请使用 auto、prompt 或 policy", *o.accept)}
+	}
+
+	server := NewFileServer(port, identity.Alias, identity.Fingerprint, DefaultDeviceModel, identity.CertFile(), identity.KeyFile(), *o.maxSessions)
+	server.SetPin(*o.pin)
+	server.SetTextSink(sink)
+	server.SetConflictStrategy(strategy)
+	server.SetLimits(fileLimit, sessionLimit)
+	server.SetDownloadDir(*o.downloadDir)
+	server.SetQuarantine(*o.quarantine)
+	server.SetAcceptHandler(acceptor, *o.acceptTimeout)
+	if *o.progress {
+		server.SetProgressListener(NewConsoleProgress(os.Stdout))
+	}
+	return server, nil
+}
+
+// runReceive receive 命令：启动接收端，监听 UDP 广播和 HTTP 文件上传请求
+func runReceive(cmd *command, args []string) error {
+	fs := newFlagSet(cmd)
+	dev := addDeviceFlags(fs)
+	recv := addReceiveFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return usageError{fmt.Sprintf("receive 不接受参数: %v", fs.Args())}
+	}
+	return serve(dev, recv, nil)
+}
+
+// runShare share 命令：下载模式，发布文件供其他设备 (LocalSend 客户端或浏览器) 拉取，同时照常接收文件
+func runShare(cmd *command, args []string) error {
+	fs := newFlagSet(cmd)
+	dev := addDeviceFlags(fs)
+	recv := addReceiveFlags(fs)
+	var files stringList
+	fs.Var(&files, "file", "要发布的文件或目录，可重复指定，也可直接写在参数末尾")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	files = append(files, fs.Args()...)
+	if len(files) == 0 {
+		return usageError{"share 需要指定要发布的文件或目录"}
+	}
+	return serve(dev, recv, files)
+}
+
+// serve 运行接收端，shareFiles 非空时同时开启下载模式
+func serve(dev *deviceOptions, recv *receiveOptions, shareFiles []string) error {
+	identity, err := dev.loadIdentity()
+	if err != nil {
+		return err
+	}
+	server, err := recv.newServer(identity, *dev.port)
+	if err != nil {
+		return err
+	}
+
+	mode := "receive"
+	if len(shareFiles) > 0 {
+		mode = "share"
+	}
+	printBanner(identity, *dev.port, mode)
+
+	// 无论发送端还是接收端，都需要监听多播，以便发现其他设备
+	discovery := startDiscovery(identity, *dev.port, true)
+
+	// 共享模式下发布文件，并在宣告和 /info 中声明开启了下载模式
+	if len(shareFiles) > 0 {
+		share, err := NewShare(shareFiles, SessionIdleTimeout)
+		if err != nil {
+			return err
+		}
+		server.SetShare(share)
+		discovery.SetDownload(true)
+		fmt.Println("[main] 已发布以下文件，对方可通过下载模式拉取:")
+		for _, f := range share.Files() {
+			fmt.Printf("  - %s (%s)\n", f.FileName, FormatSize(f.Size))
+		}
+		// 没有 LocalSend 客户端的设备可以用浏览器打开共享页面
+		for _, u := range webShareURLs(*dev.port) {
+			fmt.Printf("[main] 浏览器访问: %s\n", u)
+		}
+	}
+
+	// 启动定期广播宣告 (Announcer)
+	// 这样其他设备打开 App 时能立即发现我
+	go discovery.StartAnnouncer(AnnounceInterval)
+
+	// 启动 HTTP 服务器
+	// 阻塞运行，处理所有入站请求 (Info, Register, Upload)
+	return server.Start()
+}
+
+// runSend send 命令：向目标设备发送文件或文本消息
+func runSend(cmd *command, args []string) error {
+	fs := newFlagSet(cmd)
+	dev := addDeviceFlags(fs)
+	var files stringList
+	fs.Var(&files, "file", "要发送的文件或目录，可重复指定，也可直接写在目标之后")
+	text := fs.String("text", "", "发送一条文本消息 (代替文件)")
+	pin := fs.String("pin", "", "发送时携带的 PIN，留空时在对方要求时提示输入")
+	parallel := fs.Int("parallel", DefaultUploadConcurrency, "同时上传的文件数")
+	timeout := fs.Duration("timeout", 0, "整个发送过程的最长时间，超时后取消会话，0 表示不限制")
+	progress := fs.Bool("progress", true, "显示传输进度 (终端中为进度条，否则定期输出日志)")
+	wait := fs.Duration("wait", DefaultResolveTimeout, "按别名或指纹查找目标时等待设备宣告的最长时间")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return usageError{"send 需要指定目标: IP[:端口]、设备别名或指纹前缀"}
+	}
+	target := fs.Arg(0)
+	files = append(files, fs.Args()[1:]...)
+	if len(files) == 0 && *text == "" {
+		return usageError{"send 需要指定要发送的文件，或通过 -text 发送文本消息"}
+	}
+	if len(files) > 0 && *text != "" {
+		return usageError{"-text 不能与文件同时使用"}
+	}
+
+	identity, err := dev.loadIdentity()
+	if err != nil {
+		return err
+	}
+	printBanner(identity, *dev.port, "send")
+
+	// 发送一次广播宣告，让局域网内其他设备知道我上线了，同时促使它们回应宣告
+	discovery := startDiscovery(identity, *dev.port, true)
+	discovery.SendAnnouncement()
+
+	// 信任库记录曾经连接过的设备指纹，防止局域网内的冒充者
+	trust, err := LoadTrustStore(identity.Dir())
+	if err != nil {
+		return err
+	}
+	sender := NewSender(identity.Alias, identity.Fingerprint, DefaultDeviceModel, *dev.port, trust)
+	sender.SetPin(*pin)
+	sender.SetConcurrency(*parallel)
+	if *progress {
+		sender.SetProgressListener(NewConsoleProgress(os.Stdout))
+	}
+
+	// 解析目标设备
+	// 传入 IP 时直接连接；传入别名或指纹时，从多播宣告中获取对方的真实 IP、端口和协议
+	peer, err := ResolveTarget(discovery.Peers(), target, *wait)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrUnreachable, err)
+	}
+
+	// Ctrl-C 或超时会中止上传并通知对方取消会话；再按一次 Ctrl-C 立即退出
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+
+	if *text != "" {
+		err = sender.SendText(ctx, peer, *text)
+	} else {
+		err = sender.SendFiles(ctx, peer, files)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Println("[main] 完成。")
+	return nil
+}
+
+// runDiscover discover 命令：宣告本机上线，等待其他设备回应后列出设备
+func runDiscover(cmd *command, args []string) error {
+	fs := newFlagSet(cmd)
+	dev := addDeviceFlags(fs)
+	wait := fs.Duration("wait", DefaultDiscoverWait, "等待设备回应的时间")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return usageError{fmt.Sprintf("discover 不接受参数: %v", fs.Args())}
+	}
+
+	identity, err := dev.loadIdentity()
+	if err != nil {
+		return err
+	}
+	discovery := startDiscovery(identity, *dev.port, false)
+	// 监听器启动后再宣告，避免错过其他设备的回应
+	time.Sleep(200 * time.Millisecond)
+	discovery.SendAnnouncement()
+	time.Sleep(*wait)
+
+	peers := discovery.Peers().List()
+	if len(peers) == 0 {
+		fmt.Printf("在 %s 内未发现任何设备\n", *wait)
+		return nil
+	}
+	sort.Slice(peers, func(i, j int) bool {
+		return peers[i].Alias < peers[j].Alias
+	})
+	fmt.Printf("发现 %d 个设备:\n%s\n", len(peers), formatPeers(peers))
+	return nil
+}
+
+// runInfo info 命令：查询设备的 /info 接口
+func runInfo(cmd *command, args []string) error {
+	fs := newFlagSet(cmd)
+	useHttp := fs.Bool("http", false, "使用 HTTP 而不是 HTTPS 连接")
+	asJson := fs.Bool("json", false, "以 JSON 格式输出")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return usageError{"info 需要且只需要一个参数: IP[:端口]"}
+	}
+	peer, ok := parseAddrTarget(fs.Arg(0))
+	if !ok {
+		return usageError{fmt.Sprintf("无效的地址 %q，请使用 IP 或 IP:端口", fs.Arg(0))}
+	}
+	if *useHttp {
+		peer.Protocol = ProtocolTypeHttp
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	info, err := FetchInfo(ctx, peer)
+	if err != nil {
+		return err
+	}
+	if *asJson {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(info)
+	}
+	fmt.Printf("别名:        %s\n", info.Alias)
+	fmt.Printf("协议版本:    %s\n", info.Version)
+	fmt.Printf("设备型号:    %s\n", info.DeviceModel)
+	fmt.Printf("设备类型:    %s\n", info.DeviceType)
+	fmt.Printf("指纹:        %s\n", info.Fingerprint)
+	fmt.Printf("端口:        %d\n", info.Port)
+	fmt.Printf("协议:        %s\n", info.Protocol)
+	fmt.Printf("下载模式:    %t\n", info.Download)
+	return nil
+}
+
+// runIdentity identity 命令：打印设备身份，-rotate 时先轮换身份
+func runIdentity(cmd *command, args []string) error {
+	fs := newFlagSet(cmd)
+	state := fs.String("state", config.StateDir, "状态目录，保存设备指纹、别名和 TLS 密钥")
+	alias := fs.String("alias", "", "修改设备别名")
+	rotate := fs.Bool("rotate", false, "重新生成设备指纹和密钥")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return usageError{fmt.Sprintf("identity 不接受参数: %v", fs.Args())}
+	}
+
+	var identity *Identity
+	var err error
+	if *rotate {
+		identity, err = RotateIdentity(*state)
+	} else {
+		identity, err = LoadOrCreateIdentity(*state)
+	}
+	if err != nil {
+		return err
+	}
+	if *alias != "" {
+		if err := identity.SetAlias(*alias); err != nil {
+			return err
+		}
+	}
+
+	if *rotate {
+		fmt.Println("[main] 设备身份已轮换，之前信任本机的设备需要重新确认。")
+	}
+	fmt.Printf("状态目录:    %s\n", identity.Dir())
+	fmt.Printf("别名:        %s\n", identity.Alias)
+	fmt.Printf("指纹:        %s\n", identity.Fingerprint)
+	fmt.Printf("创建时间:    %s\n", identity.CreatedAt.Format(time.RFC3339))
+	return nil
+}
+
+// runConfig config 命令：打印生效的配置及每项的来源
+// 配置无效时 main 已在加载时报错退出，因此能运行到这里即表示配置有效
+func runConfig(cmd *command, args []string) error {
+	fs := newFlagSet(cmd)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return usageError{fmt.Sprintf("config 不接受参数: %v", fs.Args())}
+	}
+
+	if config.Path != "" {
+		fmt.Printf("配置文件:    %s\n", config.Path)
+	} else {
+		fmt.Printf("配置文件:    未使用 (默认位置 %s)\n", DefaultConfigPath())
+	}
+	for _, item := range configItems {
+		fmt.Printf("  %-18s %-28s %s\n", item.key, item.get(config), config.Source(item.key))
+	}
+	return nil
+}
+
+// logPeerEvents 打印设备表的变化
+func logPeerEvents(events <-chan PeerEvent) {
+	for ev := range events {
+		p := ev.Peer
+		fmt.Printf("[发现服务] 设备%s: %s (%s) 位于 %s:%d\n", ev.Type, p.Alias, p.DeviceModel, p.IP, p.Port)
+	}
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config 可通过配置文件和环境变量调整的设置，同一个程序可以在不同机器上使用不同的配置
+//
+// 默认读取状态目录下的 config.yaml，不存在时忽略；也可以通过全局选项 -config 或
+// 环境变量 STRAWBERRY_CONFIG 指定路径，此时文件必须存在。所有配置项都是可选的:
+//
+//	https: true                          # 是否使用 https，需与对方设备一致
+//	port: 53317                          # 监听端口 (1-65535)
+//	alias: 客厅电脑                      # 设备别名，留空时沿用设备身份中保存的别名
+//	state_dir: /var/lib/strawberryShare  # 状态目录，保存设备指纹、别名和 TLS 密钥
+//	download_dir: /srv/incoming          # 接收文件的保存目录
+//	connect_timeout: 60s                 # 连接超时，Go 时长格式，如 30s、2m
+//	multicast_group: 224.0.0.167         # 多播组地址，必须是 IPv4 多播地址
+//	announce_interval: 2s                # 多播宣告间隔，必须小于设备超时 (30s)
+//
+// 每个配置项都可以用环境变量覆盖，变量名为 STRAWBERRY_ 加上大写的配置项名，
+// 如 STRAWBERRY_PORT、STRAWBERRY_DOWNLOAD_DIR，值为空的环境变量会被忽略。
+// 优先级从低到高依次为: 默认值、配置文件、环境变量、命令行选项。
+// 未知的配置项、重复的配置项和无效的值都会在启动时报错，并指出所在的行或环境变量。
+type Config struct {
+	Https            bool
+	Port             int
+	Alias            string
+	StateDir         string
+	DownloadDir      string
+	ConnectTimeout   time.Duration
+	MulticastGroup   string
+	AnnounceInterval time.Duration
+
+	Path    string            // 读取的配置文件，未使用配置文件时为空
+	sources map[string]string // 每个配置项的来源，不在其中的为默认值
+}
+
+// configItem 一个配置项
+type configItem struct {
+	key string                          // 配置文件中的键名
+	set func(c *Config, v string) error // 解析并校验值
+	get func(c *Config) string          // 当前值的文本形式
+}
+
+// configItems 所有配置项，按文档中的顺序排列
+var configItems = []configItem{
+	{
+		key: "https",
+		set: func(c *Config, v string) (err error) { c.Https, err = parseConfigBool(v); return err },
+		get: func(c *Config) string { return strconv.FormatBool(c.Https) },
+	},
+	{
+		key: "port",
+		set: func(c *Config, v string) (err error) { c.Port, err = parseConfigPort(v); return err },
+		get: func(c *Config) string { return strconv.Itoa(c.Port) },
+	},
+	{
+		key: "alias",
+		set: func(c *Config, v string) error { c.Alias = v; return nil },
+		get: func(c *Config) string { return c.Alias },
+	},
+	{
+		key: "state_dir",
+		set: func(c *Config, v string) (err error) { c.StateDir, err = parseConfigDir(v); return err },
+		get: func(c *Config) string { return c.StateDir },
+	},
+	{
+		key: "download_dir",
+		set: func(c *Config, v string) (err error) { c.DownloadDir, err = parseConfigDir(v); return err },
+		get: func(c *Config) string { return c.DownloadDir },
+	},
+	{
+		key: "connect_timeout",
+		set: func(c *Config, v string) (err error) { c.ConnectTimeout, err = parseConfigDuration(v); return err },
+		get: func(c *Config) string { return c.ConnectTimeout.String() },
+	},
+	{
+		key: "multicast_group",
+		set: func(c *Config, v string) (err error) { c.MulticastGroup, err = parseMulticastGroup(v); return err },
+		get: func(c *Config) string { return c.MulticastGroup },
+	},
+	{
+		key: "announce_interval",
+		set: func(c *Config, v string) error {
+			d, err := parseConfigDuration(v)
+			if err != nil {
+				return err
+			}
+			if d >= PeerTTL {
+				return fmt.Errorf("必须小于设备超时 %s，否则其他设备会认为本机已下线，当前为 %s", PeerTTL, d)
+			}
+			c.AnnounceInterval = d
+			return nil
+		},
+		get: func(c *Config) string { return c.AnnounceInterval.String() },
+	},
+}
+
+// config 当前生效的配置，由 main 在分发子命令之前加载
+var config = DefaultConfig()
+
+// DefaultConfig 返回全部为默认值的配置
+func DefaultConfig() *Config {
+	return &Config{
+		Https:            DefaultHttps,
+		Port:             DefaultPort,
+		StateDir:         DefaultStateDir(),
+		DownloadDir:      DefaultDownloadDir,
+		ConnectTimeout:   DefaultConnectTimeout,
+		MulticastGroup:   DefaultMulticastGroup,
+		AnnounceInterval: DefaultAnnounceInterval,
+		sources:          make(map[string]string),
+	}
+}
+
+// DefaultConfigPath 默认配置文件路径
+func DefaultConfigPath() string {
+	return filepath.Join(DefaultStateDir(), ConfigFileName)
+}
+
+// LoadConfig 依次读取配置文件和环境变量，返回校验通过的配置
+// path 为空时使用 STRAWBERRY_CONFIG，仍为空时尝试默认配置文件
+func LoadConfig(path string) (*Config, error) {
+	c := DefaultConfig()
+	if path == "" {
+		path = os.Getenv(ConfigPathEnv)
+	}
+	explicit := path != ""
+	if !explicit {
+		path = DefaultConfigPath()
+	}
+
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		c.Path = path
+		if err := c.loadFile(path, data); err != nil {
+			return nil, err
+		}
+	case errors.Is(err, os.ErrNotExist) && !explicit:
+		// 默认配置文件是可选的
+	default:
+		return nil, fmt.Errorf("读取配置文件失败: %v", err)
+	}
+
+	if err := c.loadEnv(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// loadFile 解析 YAML 配置文件，报告所有错误而不只是第一个
+func (c *Config) loadFile(path string, data []byte) error {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("解析配置文件 %s 失败: %v", path, err)
+	}
+	if len(doc.Content) == 0 {
+		return nil // 空文件
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return fmt.Errorf("%s 第 %d 行: 配置文件应为 \"配置项: 值\" 的形式", path, root.Line)
+	}
+
+	var errs []error
+	seen := make(map[string]int)
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		k, v := root.Content[i], root.Content[i+1]
+		source := fmt.Sprintf("%s 第 %d 行", path, k.Line)
+		item := findConfigItem(k.Value)
+		if item == nil {
+			errs = append(errs, fmt.Errorf("%s: 未知的配置项 %q，可选: %s", source, k.Value, configKeys()))
+			continue
+		}
+		if line, ok := seen[k.Value]; ok {
+			errs = append(errs, fmt.Errorf("%s: 配置项 %s 重复，第 %d 行已设置", source, k.Value, line))
+			continue
+		}
+		seen[k.Value] = k.Line
+		if v.Kind != yaml.ScalarNode || v.Tag == "!!null" {
+			errs = append(errs, fmt.Errorf("%s: 配置项 %s 需要一个值", source, k.Value))
+			continue
+		}
+		errs = append(errs, c.set(item, v.Value, source))
+	}
+	return errors.Join(errs...)
+}
+
+// loadEnv 用环境变量覆盖配置项
+func (c *Config) loadEnv() error {
+	var errs []error
+	for i := range configItems {
+		item := &configItems[i]
+		name := configEnvName(item.key)
+		if v := os.Getenv(name); v != "" {
+			errs = append(errs, c.set(item, v, "环境变量 "+name))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// set 设置配置项并记录来源，值无效时错误中包含来源
+func (c *Config) set(item *configItem, value, source string) error {
+	if err := item.set(c, strings.TrimSpace(value)); err != nil {
+		return fmt.Errorf("%s: %s 无效: %v", source, item.key, err)
+	}
+	c.sources[item.key] = source
+	return nil
+}
+
+// Source 返回配置项的来源，如 "环境变量 STRAWBERRY_PORT"
+func (c *Config) Source(key string) string {
+	if source, ok := c.sources[key]; ok {
+		return source
+	}
+	return "默认值"
+}
+
+// Apply 把配置写入全局设置
+// 端口、别名和状态目录作为命令行选项的默认值，由各子命令读取
+func (c *Config) Apply() {
+	IsHttps = c.Https
+	MulticastGroup = c.MulticastGroup
+	ConnectTimeout = c.ConnectTimeout
+	AnnounceInterval = c.AnnounceInterval
+	DownloadDir = c.DownloadDir
+	updateProtocolType()
+}
+
+// findConfigItem 按键名查找配置项
+func findConfigItem(key string) *configItem {
+	for i := range configItems {
+		if configItems[i].key == key {
+			return &configItems[i]
+		}
+	}
+	return nil
+}
+
+// configKeys 所有配置项的键名，用于错误提示
+func configKeys() string {
+	keys := make([]string, len(configItems))
+	for i, item := range configItems {
+		keys[i] = item.key
+	}
+	return strings.Join(keys, "、")
+}
+
+// configEnvName 配置项对应的环境变量名
+func configEnvName(key string) string {
+	return ConfigEnvPrefix + strings.ToUpper(key)
+}
+
+func parseConfigBool(v string) (bool, error) {
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("应为 true 或 false，当前为 %q", v)
+	}
+	return b, nil
+}
+
+func parseConfigPort(v string) (int, error) {
+	port, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("应为整数，当前为 %q", v)
+	}
+	if port < 1 || port > 65535 {
+		return 0, fmt.Errorf("应在 1-65535 之间，当前为 %d", port)
+	}
+	return port, nil
+}
+
+func parseConfigDir(v string) (string, error) {
+	if v == "" {
+		return "", errors.New("不能为空")
+	}
+	return v, nil
+}
+
+func parseConfigDuration(v string) (time.Duration, error) {
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("应为带单位的时长，如 30s、2m，当前为 %q", v)
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("必须大于 0，当前为 %s", d)
+	}
+	return d, nil
+}
+
+func parseMulticastGroup(v string) (string, error) {
+	ip := net.ParseIP(v)
+	if ip == nil || ip.To4() == nil || !ip.IsMulticast() {
+		return "", fmt.Errorf("应为 IPv4 多播地址 (224.0.0.0-239.255.255.255)，当前为 %q", v)
+	}
+	return ip.String(), nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ConflictStrategy 接收文件与已有文件重名时的处理方式
+type ConflictStrategy string
+
+const (
+	ConflictRename    ConflictStrategy = "rename"    // 追加序号，如 file (1).txt
+	ConflictOverwrite ConflictStrategy = "overwrite" // 覆盖已有文件
+	ConflictSkip      ConflictStrategy = "skip"      // 保留已有文件，丢弃新文件
+	ConflictTimestamp ConflictStrategy = "timestamp" // 两者都保留，新文件名追加时间戳
+)
+
+// maxRenameAttempts 追加序号时的最大尝试次数
+const maxRenameAttempts = 10000
+
+// ErrFileSkipped 目标文件已存在，按 skip 策略跳过
+var ErrFileSkipped = errors.New("文件已存在，已跳过")
+
+// ParseConflictStrategy 解析重名处理策略
+func ParseConflictStrategy(s string) (ConflictStrategy, error) {
+	switch st := ConflictStrategy(s); st {
+	case ConflictRename, ConflictOverwrite, ConflictSkip, ConflictTimestamp:
+		return st, nil
+	}
+	return "", fmt.Errorf("无效的重名处理策略 %q，可选: rename、overwrite、skip、timestamp", s)
+}
+
+// placeFile 按重名策略把已写完的临时文件 tmpPath 放到目标位置 path，返回最终路径
+// 覆盖模式直接 rename（原子替换）；其他模式用硬链接实现"不存在才放置"，
+// 两个同名上传同时完成时也不会互相覆盖。skip 策略下目标已存在时返回 ErrFileSkipped。
+// 成功或跳过时 tmpPath 都会被移除。
+func placeFile(tmpPath, path string, strategy ConflictStrategy) (string, error) {
+	if strategy == ConflictOverwrite {
+		if err := os.Rename(tmpPath, path); err != nil {
+			return "", err
+		}
+		return path, nil
+	}
+
+	err := placeExclusive(tmpPath, path)
+	if !errors.Is(err, os.ErrExist) {
+		if err != nil {
+			return "", err
+		}
+		return path, nil
+	}
+	if strategy == ConflictSkip {
+		os.Remove(tmpPath)
+		return "", ErrFileSkipped
+	}
+
+	ext := filepath.Ext(path)
+	stem := strings.TrimSuffix(path, ext)
+	if strategy == ConflictTimestamp {
+		stem += "_" + time.Now().Format("20060102-150405")
+		candidate := stem + ext
+		err := placeExclusive(tmpPath, candidate)
+		if !errors.Is(err, os.ErrExist) {
+			if err != nil {
+				return "", err
+			}
+			return candidate, nil
+		}
+	}
+
+	for i := 1; i <= maxRenameAttempts; i++ {
+		candidate := fmt.Sprintf("%s (%d)%s", stem, i, ext)
+		err := placeExclusive(tmpPath, candidate)
+		if errors.Is(err, os.ErrExist) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		return candidate, nil
+	}
+	return "", fmt.Errorf("无法为 %s 找到可用的文件名", path)
+}
+
+// linkFile 创建硬链接，测试中可替换以模拟不支持硬链接的文件系统
+var linkFile = os.Link
+
+// placeExclusive 仅在 path 不存在时把 tmpPath 移动过去，已存在时返回 os.ErrExist
+// 优先使用硬链接；文件系统不支持硬链接 (如 FAT) 时，先独占创建占位文件再覆盖，
+// 此时在覆盖之前 path 会短暂地以空文件出现，覆盖失败时移除占位文件。
+func placeExclusive(tmpPath, path string) error {
+	err := linkFile(tmpPath, path)
+	if err == nil {
+		return os.Remove(tmpPath)
+	}
+	if errors.Is(err, os.ErrExist) {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	f.Close()
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(path)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// placeConcurrently 让 n 个临时文件同时以 strategy 放到 dir/a.txt，返回各自的最终路径和错误
+func placeConcurrently(t *testing.T, dir string, n int, strategy ConflictStrategy) ([]string, []error) {
+	t.Helper()
+	tmps := make([]string, n)
+	for i := range tmps {
+		tmps[i] = filepath.Join(dir, fmt.Sprintf(".tmp-%d", i))
+		if err := os.WriteFile(tmps[i], []byte(fmt.Sprint(i)), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	paths := make([]string, n)
+	errs := make([]error, n)
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := range tmps {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			paths[i], errs[i] = placeFile(tmps[i], filepath.Join(dir, "a.txt"), strategy)
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	for _, tmp := range tmps {
+		if _, err := os.Stat(tmp); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("临时文件 %s 未被移除", tmp)
+		}
+	}
+	return paths, errs
+}
+
+func TestPlaceFileConcurrent(t *testing.T) {
+	const n = 32
+	for _, hardlink := range []bool{true, false} {
+		for _, strategy := range []ConflictStrategy{ConflictRename, ConflictTimestamp, ConflictSkip, ConflictOverwrite} {
+			t.Run(fmt.Sprintf("%s/hardlink=%t", strategy, hardlink), func(t *testing.T) {
+				if !hardlink {
+					old := linkFile
+					linkFile = func(string, string) error { return errors.ErrUnsupported }
+					defer func() { linkFile = old }()
+				}
+
+				dir := t.TempDir()
+				paths, errs := placeConcurrently(t, dir, n, strategy)
+
+				// 最终路径 -> 写入的内容 (即第几个文件)
+				placed := make(map[string]int)
+				skipped := 0
+				for i := range paths {
+					if errors.Is(errs[i], ErrFileSkipped) {
+						skipped++
+						continue
+					}
+					if errs[i] != nil {
+						t.Fatalf("placeFile #%d: %v", i, errs[i])
+					}
+					placed[paths[i]] = i
+				}
+
+				switch strategy {
+				case ConflictRename, ConflictTimestamp:
+					// 每个文件都以不同的名字保留下来，且内容正确
+					if len(placed) != n {
+						t.Fatalf("保留了 %d 个不同的文件名，应为 %d", len(placed), n)
+					}
+					for path, i := range placed {
+						data, err := os.ReadFile(path)
+						if err != nil || string(data) != fmt.Sprint(i) {
+							t.Errorf("%s 的内容为 %q (%v)，应为 %q", path, data, err, fmt.Sprint(i))
+						}
+					}
+				case ConflictSkip:
+					if len(placed) != 1 || skipped != n-1 {
+						t.Fatalf("放置 %d 个，跳过 %d 个；应放置 1 个，跳过 %d 个", len(placed), skipped, n-1)
+					}
+				case ConflictOverwrite:
+					if len(placed) != 1 {
+						t.Fatalf("覆盖模式得到 %d 个不同路径，应为 1", len(placed))
+					}
+				}
+
+				entries, err := os.ReadDir(dir)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if want := len(placed); len(entries) != want {
+					t.Errorf("目录中有 %d 个文件，应为 %d", len(entries), want)
+				}
+			})
+		}
+	}
+}
+
+func TestPlaceExclusiveFallbackCleanup(t *testing.T) {
+	old := linkFile
+	linkFile = func(string, string) error { return errors.ErrUnsupported }
+	defer func() { linkFile = old }()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.txt")
+	if err := placeExclusive(filepath.Join(dir, "missing"), path); err == nil {
+		t.Fatal("临时文件不存在时 placeExclusive 应当失败")
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("覆盖失败后占位文件未被移除: %v", err)
+	}
+}
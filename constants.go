@@ -7,8 +7,8 @@ import (
 )
 
 const (
-	// IsHttps 是否开启https
-	IsHttps = true
+	// DefaultHttps 默认是否开启https
+	DefaultHttps = true
 	// DefaultPort LocalSend 默认端口
 	DefaultPort = 53317
 
@@ -30,11 +30,107 @@ const (
 	// UDPSocketBufferSize UDP Socket 缓冲区大小
 	UDPSocketBufferSize = 1024 * 1024
 
-	// ConnectTimeout 连接超时时间
-	ConnectTimeout = 60 * time.Second
+	// DefaultConnectTimeout 默认连接超时时间
+	DefaultConnectTimeout = 60 * time.Second
+
+	// DefaultAnnounceInterval 默认多播宣告间隔
+	DefaultAnnounceInterval = 2 * time.Second
+
+	// PeerTTL 设备超过此时间未宣告即视为下线
+	PeerTTL = 30 * time.Second
+
+	// PeerReapInterval 设备表清理周期
+	PeerReapInterval = 5 * time.Second
+
+	// DefaultResolveTimeout 按别名或指纹查找设备时的默认等待时间
+	DefaultResolveTimeout = 5 * time.Second
+
+	// DefaultDiscoverWait discover 命令等待设备宣告的时间
+	DefaultDiscoverWait = 3 * time.Second
+
+	// StateDirName 状态目录名，用于保存设备身份等持久化数据
+	StateDirName = "strawberryShare"
+
+	// SessionIdleTimeout 会话无活动超过此时间即被清理
+	SessionIdleTimeout = 5 * time.Minute
+
+	// SessionJanitorInterval 会话清理周期
+	SessionJanitorInterval = 30 * time.Second
+
+	// DefaultAcceptTimeout 等待用户确认传输请求的默认时间，超时视为拒绝
+	DefaultAcceptTimeout = 60 * time.Second
+
+	// PinMaxFailures 同一地址连续输错 PIN 的次数上限
+	PinMaxFailures = 3
+
+	// PinLockout 超过 PIN 错误上限后的锁定时间
+	PinLockout = 5 * time.Minute
+
+	// PinPromptAttempts 发送端收到 401 后提示输入 PIN 的最多次数
+	PinPromptAttempts = 3
+
+	// DefaultMaxSessions 默认同时进行的会话上限，1 即 LocalSend 的单会话模式
+	DefaultMaxSessions = 1
+
+	// ResumeHeader prepare-upload 请求和响应头，值为 "1" 表示发送方 (请求) 或接收方 (响应) 支持续传扩展
+	ResumeHeader = "X-Strawberry-Resume"
+
+	// ResumeOffsetPath 续传扩展接口，查询某个文件已接收的字节数
+	ResumeOffsetPath = "/api/strawberry/v1/upload-offset"
+
+	// ResumeAttempts 发送端上传中断后最多续传的次数
+	ResumeAttempts = 5
+
+	// UploadStallTimeout 上传过程中连续这么久没有数据即视为连接中断 (如 Wi-Fi 断开)，接收端保留数据等待续传
+	UploadStallTimeout = 30 * time.Second
+
+	// UploadTakeoverTimeout 同一文件的新上传请求等待旧请求退出的最长时间
+	UploadTakeoverTimeout = 10 * time.Second
+
+	// ServerReadHeaderTimeout 读取请求头的超时时间
+	ServerReadHeaderTimeout = 30 * time.Second
+
+	// ServerReadTimeout 读取整个请求的超时时间，上传接口按 UploadStallTimeout 逐次延长
+	ServerReadTimeout = 60 * time.Second
+
+	// ServerIdleTimeout keep-alive 连接的空闲超时
+	ServerIdleTimeout = 2 * time.Minute
+
+	// DefaultUploadConcurrency 发送端同一会话内同时上传的文件数
+	DefaultUploadConcurrency = 4
 
 	// DefaultDownloadDir 默认下载目录
 	DefaultDownloadDir = "downloads"
+
+	// ConfigFileName 默认配置文件名，位于状态目录下
+	ConfigFileName = "config.yaml"
+
+	// ConfigPathEnv 指定配置文件路径的环境变量
+	ConfigPathEnv = "STRAWBERRY_CONFIG"
+
+	// ConfigEnvPrefix 覆盖配置项的环境变量前缀，后接大写的配置项名，如 STRAWBERRY_PORT
+	ConfigEnvPrefix = "STRAWBERRY_"
+
+	// QuarantineDirName 哈希校验失败的文件隔离目录，位于保存目录下
+	QuarantineDirName = ".quarantine"
+
+	// MaxTextMessageSize 文本消息的最大长度
+	MaxTextMessageSize = 64 << 10
+
+	// TextHookTimeout 文本消息处理命令的最长运行时间
+	TextHookTimeout = 30 * time.Second
+
+	// TextHookBurst 文本消息处理命令最多连续执行的次数
+	TextHookBurst = 5
+
+	// TextHookInterval 超过 TextHookBurst 后，文本消息处理命令的最小执行间隔
+	TextHookInterval = 2 * time.Second
+
+	// ProgressInterval 传输中进度回调的最小间隔
+	ProgressInterval = 200 * time.Millisecond
+
+	// ProgressLogInterval 非终端输出时进度日志的间隔
+	ProgressLogInterval = 5 * time.Second
 )
 
 var (
@@ -43,7 +139,30 @@ var (
 	ProtocolTypeHttps      model.ProtocolType = "https"
 )
 
+// 以下设置可通过配置文件或环境变量修改，启动时由 Config.Apply 写入 (见 config.go)
+var (
+	// IsHttps 是否开启https
+	IsHttps = DefaultHttps
+
+	// MulticastGroup 多播组地址
+	MulticastGroup = DefaultMulticastGroup
+
+	// ConnectTimeout 连接超时时间
+	ConnectTimeout = DefaultConnectTimeout
+
+	// AnnounceInterval 接收端定期多播宣告的间隔
+	AnnounceInterval = DefaultAnnounceInterval
+
+	// DownloadDir 接收文件的保存目录
+	DownloadDir = DefaultDownloadDir
+)
+
 func init() {
+	updateProtocolType()
+}
+
+// updateProtocolType 根据 IsHttps 更新本机使用的协议
+func updateProtocolType() {
 	if IsHttps {
 		ProtocolTypeHttpStatus = ProtocolTypeHttps
 	} else {
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net"
+	"sync/atomic"
 	"time"
 )
 
@@ -18,6 +19,12 @@ type MulticastService struct {
 	deviceModel string
 	deviceType  model.DeviceType
 	port        int // 本机 HTTP 服务端口，告知对方通过此端口连接我
+
+	// peers 记录监听到的其他设备
+	peers *PeerRegistry
+
+	// download 是否在宣告中声明开启了下载模式
+	download atomic.Bool
 }
 
 // NewMulticastService 创建发现服务实例
@@ -28,14 +35,25 @@ func NewMulticastService(alias, fingerprint, deviceModel string, port int) *Mult
 		deviceModel: deviceModel,
 		deviceType:  model.DeviceTypeDesktop, // 这里硬编码为 Desktop，可根据实际运行环境修改
 		port:        port,
+		peers:       NewPeerRegistry(PeerTTL),
 	}
 }
 
+// Peers 返回发现服务维护的设备表
+func (s *MulticastService) Peers() *PeerRegistry {
+	return s.peers
+}
+
+// SetDownload 设置宣告中的下载模式标志，开启或关闭共享时调用
+func (s *MulticastService) SetDownload(enabled bool) {
+	s.download.Store(enabled)
+}
+
 // StartListener 启动 UDP 多播监听
 // 这是一个阻塞方法，建议在 goroutine 中运行
 func (s *MulticastService) StartListener() {
 	// 解析多播地址
-	addr, err := net.ResolveUDPAddr("udp", fmt.Sprintf("%s:%d", DefaultMulticastGroup, s.port))
+	addr, err := net.ResolveUDPAddr("udp", fmt.Sprintf("%s:%d", MulticastGroup, s.port))
 	if err != nil {
 		fmt.Printf("[发现服务] 解析 UDP 地址失败: %v\n", err)
 		return
@@ -56,7 +74,7 @@ func (s *MulticastService) StartListener() {
 		return
 	}
 
-	fmt.Printf("[发现服务] 正在监听多播 %s:%d\n", DefaultMulticastGroup, s.port)
+	fmt.Printf("[发现服务] 正在监听多播 %s:%d\n", MulticastGroup, s.port)
 
 	buf := make([]byte, UDPBufferSize) // 最大 UDP 包大小
 	for {
@@ -80,7 +98,8 @@ func (s *MulticastService) StartListener() {
 			continue
 		}
 
-		fmt.Printf("[发现服务] 发现设备: %s (%s) 位于 %s:%d\n", dto.Alias, dto.DeviceModel, src.IP, dto.Port)
+		// 记录到设备表，上线/下线日志由设备表事件驱动
+		s.peers.Upsert(dto, src.IP.String())
 
 		// 逻辑扩展点：
 		// LocalSend 的标准行为是：
@@ -100,7 +119,7 @@ func (s *MulticastService) StartListener() {
 func (s *MulticastService) SendAnnouncement() {
 	// 目标地址：多播组 IP + 端口
 	// 注意：这里的端口必须与接收端监听的端口一致 (53317)
-	addr, err := net.ResolveUDPAddr("udp", fmt.Sprintf("%s:%d", DefaultMulticastGroup, DefaultPort))
+	addr, err := net.ResolveUDPAddr("udp", fmt.Sprintf("%s:%d", MulticastGroup, DefaultPort))
 	if err != nil {
 		fmt.Printf("[发现服务] 解析 UDP 地址失败: %v\n", err)
 		return
@@ -123,7 +142,7 @@ func (s *MulticastService) SendAnnouncement() {
 		Fingerprint:  s.fingerprint,
 		Port:         s.port, // 告知对方我的 HTTP 服务端口
 		Protocol:     ProtocolTypeHttpStatus,
-		Download:     false,
+		Download:     s.download.Load(),
 		Announcement: true, // v1 标志
 		Announce:     true, // v2 标志
 	}
@@ -2,4 +2,7 @@ module chrelyonly-localsend-go
 
 go 1.25
 
-require github.com/google/uuid v1.6.0
+require (
+	github.com/google/uuid v1.6.0
+	gopkg.in/yaml.v3 v3.0.1
+)
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	identityFileName = "identity.json"
+	certFileName     = "cert.pem"
+	keyFileName      = "key.pem"
+)
+
+// Identity 是持久化的设备身份
+// 保存在状态目录中，首次运行时创建，之后每次启动复用，
+// 这样对端设备才能识别出"还是同一台设备"，信任关系和去重才有意义。
+// 指纹由本机自签名证书计算得出 (见 CertFingerprint)，对端可在 TLS 握手时校验。
+type Identity struct {
+	Fingerprint string    `json:"fingerprint"` // 设备指纹，即证书的 SHA-256
+	Alias       string    `json:"alias"`       // 设备别名
+	CreatedAt   time.Time `json:"createdAt"`   // 身份创建时间
+
+	dir string // 所在状态目录
+}
+
+// DefaultStateDir 返回默认状态目录，例如 ~/.config/strawberryShare
+// 无法获取用户配置目录时退回到当前目录下的 .strawberryShare
+func DefaultStateDir() string {
+	if dir, err := os.UserConfigDir(); err == nil {
+		return filepath.Join(dir, StateDirName)
+	}
+	return "." + StateDirName
+}
+
+// LoadOrCreateIdentity 从状态目录加载设备身份，不存在时新建
+func LoadOrCreateIdentity(dir string) (*Identity, error) {
+	data, err := os.ReadFile(filepath.Join(dir, identityFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return createIdentity(dir, DefaultAlias)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取设备身份失败: %v", err)
+	}
+
+	id := &Identity{dir: dir}
+	if err := json.Unmarshal(data, id); err != nil {
+		return nil, fmt.Errorf("解析设备身份失败 (%s): %v", identityFileName, err)
+	}
+	stored := id.Fingerprint
+	if err := id.ensureTLSMaterial(); err != nil {
+		return nil, err
+	}
+	// 证书被替换或重新生成过时，以证书为准更新记录的指纹
+	if id.Fingerprint != stored {
+		if err := id.Save(); err != nil {
+			return nil, err
+		}
+	}
+	return id, nil
+}
+
+// RotateIdentity 丢弃旧身份并生成新的指纹和密钥材料，别名保持不变
+// 轮换后，之前信任本机的设备会把本机视为新设备。
+func RotateIdentity(dir string) (*Identity, error) {
+	alias := DefaultAlias
+	if old, err := LoadOrCreateIdentity(dir); err == nil {
+		alias = old.Alias
+	}
+	for _, name := range []string{certFileName, keyFileName} {
+		if err := os.Remove(filepath.Join(dir, name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("删除旧密钥材料失败: %v", err)
+		}
+	}
+	return createIdentity(dir, alias)
+}
+
+// createIdentity 生成新身份并写入状态目录
+func createIdentity(dir, alias string) (*Identity, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("创建状态目录失败: %v", err)
+	}
+	id := &Identity{
+		Alias:     alias,
+		CreatedAt: time.Now(),
+		dir:       dir,
+	}
+	if err := id.ensureTLSMaterial(); err != nil {
+		return nil, err
+	}
+	if err := id.Save(); err != nil {
+		return nil, err
+	}
+	return id, nil
+}
+
+// Save 将身份写回状态目录
+func (id *Identity) Save() error {
+	data, err := json.MarshalIndent(id, "", "  ")
+	if err != nil {
+		return fmt.Errorf("序列化设备身份失败: %v", err)
+	}
+	if err := writeFileAtomic(filepath.Join(id.dir, identityFileName), data, 0600); err != nil {
+		return fmt.Errorf("保存设备身份失败: %v", err)
+	}
+	return nil
+}
+
+// SetAlias 修改别名并持久化
+func (id *Identity) SetAlias(alias string) error {
+	if alias == id.Alias {
+		return nil
+	}
+	id.Alias = alias
+	return id.Save()
+}
+
+// Dir 返回身份所在的状态目录
+func (id *Identity) Dir() string {
+	return id.dir
+}
+
+// CertFile 返回 TLS 证书路径
+func (id *Identity) CertFile() string {
+	return filepath.Join(id.dir, certFileName)
+}
+
+// KeyFile 返回 TLS 私钥路径
+func (id *Identity) KeyFile() string {
+	return filepath.Join(id.dir, keyFileName)
+}
+
+// ensureTLSMaterial 确保状态目录中存在本机专属的 TLS 证书和私钥，并据此计算指纹
+// 缺失时生成新的自签名证书，每台设备的私钥都不相同
+func (id *Identity) ensureTLSMaterial() error {
+	_, certErr := os.Stat(id.CertFile())
+	_, keyErr := os.Stat(id.KeyFile())
+	if certErr != nil || keyErr != nil {
+		if err := generateCertificate(id.CertFile(), id.KeyFile(), StateDirName); err != nil {
+			return fmt.Errorf("初始化 TLS 密钥材料失败: %v", err)
+		}
+	}
+
+	fingerprint, err := certFileFingerprint(id.CertFile())
+	if err != nil {
+		return err
+	}
+	id.Fingerprint = fingerprint
+	return nil
+}
+
+// writeFileAtomic 先写临时文件再重命名，避免写到一半时留下损坏的文件
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
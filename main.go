@@ -1,84 +1,200 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
-	"log"
-	"time"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
 
-	"github.com/google/uuid"
+// 退出码，供脚本区分失败原因
+const (
+	ExitOK          = 0 // 成功
+	ExitError       = 1 // 其他错误
+	ExitUsage       = 2 // 命令行参数或配置错误
+	ExitUnreachable = 3 // 找不到或无法连接目标设备
+	ExitRejected    = 4 // 对方拒绝了请求 (包括 PIN 未通过)
+	ExitBusy        = 5 // 对方正忙
+	ExitIntegrity   = 6 // 对方校验文件完整性失败
+	ExitUntrusted   = 7 // 对方证书指纹与记录不符
+	ExitCancelled   = 8 // 被 Ctrl-C 中断或超时
 )
 
-// main 是程序的入口点
-// 支持两种模式：
-// 1. server (默认): 启动接收端，监听 UDP 广播和 HTTP 文件上传请求
-// 2. sender: 启动发送端，向指定 IP 发送文件
+// command 一个子命令
+type command struct {
+	name    string
+	args    string // 用法中的参数部分
+	summary string
+	run     func(cmd *command, args []string) error
+}
+
+// commands 所有子命令，按帮助中的显示顺序排列
+var commands = []command{
+	{"receive", "[选项]", "接收文件和文本消息", runReceive},
+	{"send", "[选项] <目标> [文件或目录...]", "向目标设备发送文件或文本消息", runSend},
+	{"discover", "[选项]", "列出局域网内的设备后退出", runDiscover},
+	{"info", "[选项] <IP[:端口]>", "查询设备信息 (/info)", runInfo},
+	{"share", "[选项] <文件或目录...>", "发布文件供其他设备下载 (下载模式和网页共享)，同时照常接收", runShare},
+	{"identity", "[选项]", "查看或轮换本机的设备身份", runIdentity},
+	{"config", "", "检查配置文件和环境变量，打印生效的配置", runConfig},
+}
+
+// usageError 命令行参数错误，退出码为 ExitUsage
+type usageError struct {
+	msg string
+}
+
+func (e usageError) Error() string {
+	return e.msg
+}
+
+// main 是程序的入口点，解析全局选项并加载配置后，按第一个参数分发到子命令
 func main() {
-	// --- 1. 解析命令行参数 ---
-	port := flag.Int("port", DefaultPort, "监听端口 (默认: 53317)")
-	alias := flag.String("alias", DefaultAlias, "设备别名")
-	mode := flag.String("mode", "server", "运行模式: server (接收) 或 sender (发送)")
-	targetIP := flag.String("target", "", "目标 IP 地址 (发送模式必填)")
-	fileToSend := flag.String("file", "", "待发送文件路径 (发送模式必填)")
-	flag.Parse()
-
-	// --- 2. 初始化设备标识 ---
-	// 实际应用中，指纹应持久化存储，以保持设备身份一致性
-	fingerprint := uuid.New().String()
-	deviceModel := DefaultDeviceModel // 设备型号
-
-	fmt.Println("------------------------------------------------")
-	fmt.Printf("strawberryShare 协议版本 v%s\n", ProtocolVersion)
-	fmt.Printf("别名:        %s\n", *alias)
-	fmt.Printf("指纹:        %s\n", fingerprint)
-	fmt.Printf("端口:        %d\n", *port)
-	fmt.Printf("模式:        %s\n", *mode)
-	fmt.Println("------------------------------------------------")
-
-	// --- 3. 初始化 UDP 发现服务 ---
-	// 无论发送端还是接收端，都需要监听多播，以便发现其他设备
-	discovery := NewMulticastService(*alias, fingerprint, deviceModel, *port)
-
-	// 异步启动 UDP 监听器
-	go discovery.StartListener()
-
-	// --- 4. 根据模式执行逻辑 ---
-	if *mode == "server" {
-		// === 接收端逻辑 ===
-
-		// 启动定期广播宣告 (Announcer)
-		// 这样其他设备打开 App 时能立即发现我
-		go discovery.StartAnnouncer(2 * time.Second)
-
-		// 启动 HTTP 服务器
-		// 阻塞运行，处理所有入站请求 (Info, Register, Upload)
-		server := NewFileServer(*port, *alias, fingerprint, deviceModel)
-		server.Start()
-
-	} else if *mode == "sender" {
-		// === 发送端逻辑 ===
-
-		if *targetIP == "" || *fileToSend == "" {
-			log.Fatal("错误: 发送模式需要指定 -target 和 -file 参数")
+	global := flag.NewFlagSet(programName(), flag.ContinueOnError)
+	global.SetOutput(io.Discard)
+	global.Usage = func() {}
+	configPath := global.String("config", "", "")
+	if err := global.Parse(os.Args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			printUsage(os.Stdout)
+			return
 		}
+		fmt.Fprintf(os.Stderr, "%v\n\n", err)
+		printUsage(os.Stderr)
+		os.Exit(ExitUsage)
+	}
+	if global.NArg() == 0 {
+		printUsage(os.Stderr)
+		os.Exit(ExitUsage)
+	}
 
-		// 发送一次广播宣告（可选）
-		// 让局域网内其他设备知道我上线了
-		discovery.SendAnnouncement()
+	// 配置在子命令解析参数之前加载，作为命令行选项的默认值
+	cfg, err := LoadConfig(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[main] 配置无效:\n%v\n", err)
+		os.Exit(ExitUsage)
+	}
+	config = cfg
+	config.Apply()
+
+	name, args := global.Arg(0), global.Args()[1:]
+	switch name {
+	case "-h", "-help", "--help", "help":
+		if len(args) > 0 {
+			if cmd := findCommand(args[0]); cmd != nil {
+				cmd.run(cmd, []string{"-h"})
+				return
+			}
+		}
+		printUsage(os.Stdout)
+		return
+	}
 
-		// 初始化发送器
-		sender := NewSender(*alias, fingerprint, deviceModel, *port)
+	cmd := findCommand(name)
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "未知命令 %q\n\n", name)
+		printUsage(os.Stderr)
+		os.Exit(ExitUsage)
+	}
+
+	err = cmd.run(cmd, args)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[main] %s 失败: %v\n", cmd.name, err)
+		os.Exit(exitCode(err))
+	}
+}
 
-		// 执行发送流程
-		// 注意：这里假设对方监听默认端口 (53317)。
-		// 完善的实现应该是先通过 Discovery 发现对方的 Port，再建立连接。
-		err := sender.SendFile(*targetIP, DefaultPort, *fileToSend)
-		if err != nil {
-			log.Fatalf("[main] 发送失败: %v", err)
+// findCommand 按名称查找子命令
+func findCommand(name string) *command {
+	for i := range commands {
+		if commands[i].name == name {
+			return &commands[i]
 		}
+	}
+	return nil
+}
+
+// programName 程序名，用于帮助信息
+func programName() string {
+	return filepath.Base(os.Args[0])
+}
+
+// printUsage 打印总体帮助
+func printUsage(out *os.File) {
+	fmt.Fprintf(out, "strawberryShare - 兼容 LocalSend v%s 协议的局域网文件传输工具\n\n", ProtocolVersion)
+	fmt.Fprintf(out, "用法: %s [-config 配置文件] <命令> [选项] [参数]\n\n命令:\n", programName())
+	for _, cmd := range commands {
+		fmt.Fprintf(out, "  %-10s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintf(out, "\n使用 \"%s help <命令>\" 或 \"%s <命令> -h\" 查看命令的选项。\n", programName(), programName())
+	fmt.Fprintf(out, "\n全局选项:\n")
+	fmt.Fprintf(out, "  -config 配置文件  YAML 配置文件，默认为 %s (不存在时忽略)，也可通过环境变量 %s 指定\n", DefaultConfigPath(), ConfigPathEnv)
+	fmt.Fprintf(out, "                    配置项可用 %s 加大写的配置项名覆盖，如 %s；命令行选项优先于配置\n", ConfigEnvPrefix, configEnvName("port"))
+	fmt.Fprintf(out, "\n退出码:\n")
+	fmt.Fprintf(out, "  %d 成功  %d 其他错误  %d 参数或配置错误  %d 无法连接  %d 被拒绝\n", ExitOK, ExitError, ExitUsage, ExitUnreachable, ExitRejected)
+	fmt.Fprintf(out, "  %d 对方正忙  %d 完整性校验失败  %d 设备指纹不符  %d 已取消或超时\n", ExitBusy, ExitIntegrity, ExitUntrusted, ExitCancelled)
+}
+
+// exitCode 根据错误原因选择退出码
+// 多个文件因不同原因失败时，按下面的顺序取第一个匹配的原因
+func exitCode(err error) int {
+	var ue usageError
+	switch {
+	case errors.As(err, &ue):
+		return ExitUsage
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return ExitCancelled
+	case errors.Is(err, ErrFingerprintMismatch):
+		return ExitUntrusted
+	case errors.Is(err, ErrIntegrity):
+		return ExitIntegrity
+	case errors.Is(err, ErrRejected):
+		return ExitRejected
+	case errors.Is(err, ErrReceiverBusy):
+		return ExitBusy
+	case errors.Is(err, ErrUnreachable), errors.Is(err, ErrUploadInterrupted):
+		return ExitUnreachable
+	}
+	return ExitError
+}
 
-		fmt.Println("[main] 完成。")
-	} else {
-		log.Fatal("[main] 无效模式。请使用 'server' 或 'sender'")
+// newFlagSet 创建子命令的参数集，-h 时打印用法和选项
+func newFlagSet(cmd *command) *flag.FlagSet {
+	fs := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	fs.Usage = func() {
+		out := fs.Output()
+		fmt.Fprintf(out, "用法: %s %s %s\n\n%s\n\n选项:\n", programName(), cmd.name, cmd.args, cmd.summary)
+		fs.PrintDefaults()
 	}
+	return fs
+}
+
+// parseFlags 解析子命令参数，参数错误包装为 usageError
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return usageError{err.Error()}
+	}
+	return nil
+}
+
+// stringList 可重复指定的字符串参数
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
 }
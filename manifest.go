@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// manifestFileName 接收清单文件名，位于每个保存目录下
+const manifestFileName = ".manifest.jsonl"
+
+// ManifestEntry 接收清单中的一条记录，每接收一个文件追加一行 JSON
+type ManifestEntry struct {
+	Time        time.Time `json:"time"`
+	SessionId   string    `json:"sessionId"`
+	Sender      string    `json:"sender"`      // 发送方别名
+	Fingerprint string    `json:"fingerprint"` // 发送方指纹
+	FileName    string    `json:"fileName"`    // 发送方提供的文件名
+	StoredAs    string    `json:"storedAs"`    // 实际保存的相对路径
+	Size        int64     `json:"size"`
+	Sha256      string    `json:"sha256"`   // 接收数据的 SHA-256
+	Verified    bool      `json:"verified"` // 发送方是否提供了哈希且校验通过
+}
+
+// manifestMu 串行化清单追加，避免并发上传写出交错的行
+var manifestMu sync.Mutex
+
+// appendManifest 向 path 追加一条记录
+func appendManifest(path string, entry ManifestEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	manifestMu.Lock()
+	defer manifestMu.Unlock()
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("打开接收清单失败: %v", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("写入接收清单失败: %v", err)
+	}
+	return f.Sync()
+}
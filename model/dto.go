@@ -89,3 +89,19 @@ type PrepareUploadResponseDto struct {
 	SessionId string            `json:"sessionId"` // 本次传输会话 ID
 	Files     map[string]string `json:"files"`     // key: fileId, value: token (用于上传时的鉴权)
 }
+
+// UploadOffsetDto 续传扩展，非 LocalSend 协议内容
+// 响应 GET /api/strawberry/v1/upload-offset
+// 告知发送方某个文件已接收的字节数，发送方从该位置继续上传
+type UploadOffsetDto struct {
+	Offset int64 `json:"offset"` // 已接收并保留的字节数
+}
+
+// PrepareDownloadResponseDto 对应 common/lib/model/dto/prepare_download_response_dto.dart
+// 下载模式 (反向传输)：POST /api/localsend/v2/prepare-download 的响应
+// 包含提供方的设备信息、下载会话 ID 和可下载的文件列表
+type PrepareDownloadResponseDto struct {
+	Info      InfoDto            `json:"info"`      // 提供方设备信息
+	SessionId string             `json:"sessionId"` // 下载会话 ID
+	Files     map[string]FileDto `json:"files"`     // 可下载的文件，key 为 fileId
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"chrelyonly-localsend-go/model"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Peer 代表一台通过多播发现的对端设备
+type Peer struct {
+	Fingerprint string             // 设备唯一标识，作为注册表的 key
+	Alias       string             // 设备别名
+	IP          string             // 最近一次宣告的来源 IP
+	Port        int                // 对方 HTTP 服务端口
+	Protocol    model.ProtocolType // 对方使用的协议 (http/https)
+	DeviceType  model.DeviceType   // 设备类型
+	DeviceModel string             // 设备型号
+	Version     string             // 协议版本
+	Download    bool               // 是否开启了下载模式
+	LastSeen    time.Time          // 最近一次收到宣告的时间
+	Target      string             // 用户指定该设备的方式，由 ResolveTarget 设置，作为信任库的 key
+}
+
+// PeerEventType 定义注册表事件类型
+type PeerEventType int
+
+const (
+	PeerAdded   PeerEventType = iota // 新设备上线
+	PeerUpdated                      // 已知设备信息变化（IP、端口、别名等）
+	PeerRemoved                      // 设备超时下线
+)
+
+func (t PeerEventType) String() string {
+	switch t {
+	case PeerAdded:
+		return "上线"
+	case PeerUpdated:
+		return "更新"
+	case PeerRemoved:
+		return "下线"
+	default:
+		return "未知"
+	}
+}
+
+// PeerEvent 注册表变化事件
+type PeerEvent struct {
+	Type PeerEventType
+	Peer Peer // 事件发生时的设备快照
+}
+
+// PeerRegistry 是并发安全的内存设备表
+// 以指纹为 key，记录最近发现的设备；超过 ttl 未再宣告的设备会被移除。
+type PeerRegistry struct {
+	mu          sync.RWMutex
+	peers       map[string]*Peer
+	ttl         time.Duration
+	subscribers []chan PeerEvent
+}
+
+// NewPeerRegistry 创建设备表，ttl 为设备的存活时间
+func NewPeerRegistry(ttl time.Duration) *PeerRegistry {
+	return &PeerRegistry{
+		peers: make(map[string]*Peer),
+		ttl:   ttl,
+	}
+}
+
+// Subscribe 订阅注册表事件
+// 返回带缓冲的通道；订阅者处理过慢时事件会被丢弃，而不会阻塞发现服务。
+func (r *PeerRegistry) Subscribe() <-chan PeerEvent {
+	ch := make(chan PeerEvent, 32)
+	r.mu.Lock()
+	r.subscribers = append(r.subscribers, ch)
+	r.mu.Unlock()
+	return ch
+}
+
+// Upsert 根据多播数据包新增或刷新设备
+func (r *PeerRegistry) Upsert(dto model.MulticastDto, ip string) {
+	now := time.Now()
+	peer := Peer{
+		Fingerprint: dto.Fingerprint,
+		Alias:       dto.Alias,
+		IP:          ip,
+		Port:        dto.Port,
+		Protocol:    dto.Protocol,
+		DeviceType:  dto.DeviceType,
+		DeviceModel: dto.DeviceModel,
+		Version:     dto.Version,
+		Download:    dto.Download,
+		LastSeen:    now,
+	}
+	// v1 设备可能不携带端口和协议，按协议默认值补全
+	if peer.Port == 0 {
+		peer.Port = DefaultPort
+	}
+	if peer.Protocol == "" {
+		peer.Protocol = ProtocolTypeHttps
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	old, ok := r.peers[peer.Fingerprint]
+	r.peers[peer.Fingerprint] = &peer
+	if !ok {
+		r.emit(PeerEvent{Type: PeerAdded, Peer: peer})
+		return
+	}
+	// 仅刷新 LastSeen 不算更新，避免每次宣告都产生事件
+	prev := *old
+	prev.LastSeen = now
+	if prev != peer {
+		r.emit(PeerEvent{Type: PeerUpdated, Peer: peer})
+	}
+}
+
+// Get 按指纹查询设备
+func (r *PeerRegistry) Get(fingerprint string) (Peer, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	p, ok := r.peers[fingerprint]
+	if !ok {
+		return Peer{}, false
+	}
+	return *p, true
+}
+
+// List 返回当前所有在线设备的快照，按别名排序
+func (r *PeerRegistry) List() []Peer {
+	r.mu.RLock()
+	list := make([]Peer, 0, len(r.peers))
+	for _, p := range r.peers {
+		list = append(list, *p)
+	}
+	r.mu.RUnlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Alias != list[j].Alias {
+			return list[i].Alias < list[j].Alias
+		}
+		return list[i].Fingerprint < list[j].Fingerprint
+	})
+	return list
+}
+
+// Expire 移除超过 ttl 未宣告的设备
+func (r *PeerRegistry) Expire() {
+	deadline := time.Now().Add(-r.ttl)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for fp, p := range r.peers {
+		if p.LastSeen.Before(deadline) {
+			delete(r.peers, fp)
+			r.emit(PeerEvent{Type: PeerRemoved, Peer: *p})
+		}
+	}
+}
+
+// StartReaper 定期清理过期设备
+// 这是一个阻塞方法，建议在 goroutine 中运行
+func (r *PeerRegistry) StartReaper(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		r.Expire()
+	}
+}
+
+// emit 向所有订阅者投递事件，调用方需持有锁
+func (r *PeerRegistry) emit(ev PeerEvent) {
+	for _, ch := range r.subscribers {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	ErrPinRequired = errors.New("需要 PIN")
+	ErrPinInvalid  = errors.New("PIN 错误")
+	ErrPinLocked   = errors.New("PIN 错误次数过多，请稍后再试")
+)
+
+// pinAttempt 记录某个地址的 PIN 失败情况
+type pinAttempt struct {
+	failures    int
+	lockedUntil time.Time
+}
+
+// PinGuard 接收 PIN 校验器
+// 对应 LocalSend v2.1 prepare-upload 的 pin 参数；
+// 同一地址连续失败 maxFailures 次后，在 lockout 时间内拒绝其所有请求。
+type PinGuard struct {
+	pin         string
+	maxFailures int
+	lockout     time.Duration
+
+	mu       sync.Mutex
+	attempts map[string]*pinAttempt // key: 来源 IP
+}
+
+// NewPinGuard 创建 PIN 校验器，pin 为空表示不需要 PIN
+func NewPinGuard(pin string, maxFailures int, lockout time.Duration) *PinGuard {
+	return &PinGuard{
+		pin:         pin,
+		maxFailures: maxFailures,
+		lockout:     lockout,
+		attempts:    make(map[string]*pinAttempt),
+	}
+}
+
+// Enabled 是否设置了 PIN
+func (g *PinGuard) Enabled() bool {
+	return g != nil && g.pin != ""
+}
+
+// Check 校验来自 ip 的 PIN
+func (g *PinGuard) Check(ip, pin string) error {
+	if !g.Enabled() {
+		return nil
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Now()
+	a := g.attempts[ip]
+	if a != nil && now.Before(a.lockedUntil) {
+		return ErrPinLocked
+	}
+
+	if pin != "" && subtle.ConstantTimeCompare([]byte(pin), []byte(g.pin)) == 1 {
+		delete(g.attempts, ip)
+		return nil
+	}
+	// 未携带 PIN 是正常的首次请求，不计入失败次数
+	if pin == "" {
+		return ErrPinRequired
+	}
+
+	if a == nil {
+		a = &pinAttempt{}
+		g.attempts[ip] = a
+	}
+	a.failures++
+	if a.failures >= g.maxFailures {
+		a.failures = 0
+		a.lockedUntil = now.Add(g.lockout)
+		return ErrPinLocked
+	}
+	return ErrPinInvalid
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// PolicyAction 规则命中后的动作
+type PolicyAction string
+
+const (
+	PolicyAccept PolicyAction = "accept" // 接受，保存到默认下载目录
+	PolicyReject PolicyAction = "reject" // 拒绝
+	PolicyRoute  PolicyAction = "route"  // 接受，并保存到规则指定的目录
+)
+
+// PolicyMatch 规则的匹配条件
+// 所有填写的条件都满足时规则才命中；未填写的条件不参与判断。
+type PolicyMatch struct {
+	Fingerprints []string `json:"fingerprints,omitempty"` // 发送方指纹前缀
+	Aliases      []string `json:"aliases,omitempty"`      // 发送方别名，支持 * ? 通配符
+	Subnets      []string `json:"subnets,omitempty"`      // 来源网段，CIDR 格式
+	MinFiles     int      `json:"minFiles,omitempty"`     // 最少文件数
+	MaxFiles     int      `json:"maxFiles,omitempty"`     // 最多文件数
+	MaxTotalSize int64    `json:"maxTotalSize,omitempty"` // 文件总大小上限（字节）
+	Extensions   []string `json:"extensions,omitempty"`   // 允许的扩展名，如 ".jpg"，每个文件都必须匹配
+	MimeTypes    []string `json:"mimeTypes,omitempty"`    // 允许的 MIME 类型，支持 "image/*"，每个文件都必须匹配
+
+	subnets []*net.IPNet
+}
+
+// PolicyRule 一条策略规则
+type PolicyRule struct {
+	Name   string       `json:"name"`
+	Match  PolicyMatch  `json:"match"`
+	Action PolicyAction `json:"action"`
+	Dir    string       `json:"dir,omitempty"` // action 为 route 时的保存目录，相对路径基于下载目录
+}
+
+// Policy 声明式的自动接收策略，适用于无人值守的接收端
+// 按顺序匹配规则，第一条命中的规则决定结果；都不命中时执行 Default。
+// 配置示例:
+//
+//	{
+//	  "default": "reject",
+//	  "rules": [
+//	    {"name": "办公网图片", "match": {"subnets": ["192.168.1.0/24"], "mimeTypes": ["image/*"]}, "action": "route", "dir": "photos"},
+//	    {"name": "小文件", "match": {"maxFiles": 5, "maxTotalSize": 10485760}, "action": "accept"}
+//	  ]
+//	}
+type Policy struct {
+	Default PolicyAction `json:"default"`
+	Rules   []PolicyRule `json:"rules"`
+}
+
+// LoadPolicy 从 JSON 文件加载策略并校验
+func LoadPolicy(file string) (*Policy, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("读取策略文件失败: %v", err)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	var p Policy
+	if err := dec.Decode(&p); err != nil {
+		return nil, fmt.Errorf("解析策略文件失败 (%s): %v", file, err)
+	}
+	if err := p.validate(); err != nil {
+		return nil, fmt.Errorf("策略文件无效 (%s): %v", file, err)
+	}
+	return &p, nil
+}
+
+// validate 校验策略，并预先解析网段
+func (p *Policy) validate() error {
+	switch p.Default {
+	case PolicyAccept, PolicyReject:
+	case "":
+		p.Default = PolicyReject
+	default:
+		return fmt.Errorf("default 只能是 accept 或 reject，实际为 %q", p.Default)
+	}
+
+	for i := range p.Rules {
+		rule := &p.Rules[i]
+		where := fmt.Sprintf("rules[%d]", i)
+		if rule.Name != "" {
+			where += fmt.Sprintf(" (%s)", rule.Name)
+		}
+
+		switch rule.Action {
+		case PolicyAccept, PolicyReject:
+			if rule.Dir != "" {
+				return fmt.Errorf("%s: 只有 route 动作可以指定 dir", where)
+			}
+		case PolicyRoute:
+			if rule.Dir == "" {
+				return fmt.Errorf("%s: route 动作必须指定 dir", where)
+			}
+		default:
+			return fmt.Errorf("%s: action 只能是 accept、reject 或 route，实际为 %q", where, rule.Action)
+		}
+
+		m := &rule.Match
+		if m.MinFiles < 0 || m.MaxFiles < 0 || m.MaxTotalSize < 0 {
+			return fmt.Errorf("%s: 文件数和大小不能为负数", where)
+		}
+		if m.MaxFiles > 0 && m.MinFiles > m.MaxFiles {
+			return fmt.Errorf("%s: minFiles (%d) 大于 maxFiles (%d)", where, m.MinFiles, m.MaxFiles)
+		}
+		for _, alias := range m.Aliases {
+			if _, err := path.Match(alias, ""); err != nil {
+				return fmt.Errorf("%s: 别名通配符 %q 无效", where, alias)
+			}
+		}
+		for _, cidr := range m.Subnets {
+			_, subnet, err := net.ParseCIDR(cidr)
+			if err != nil {
+				return fmt.Errorf("%s: 网段 %q 无效: %v", where, cidr, err)
+			}
+			m.subnets = append(m.subnets, subnet)
+		}
+	}
+	return nil
+}
+
+// Decide 实现 AcceptHandler
+func (p *Policy) Decide(ctx context.Context, req TransferRequest) (Decision, error) {
+	for _, rule := range p.Rules {
+		if !rule.Match.matches(req) {
+			continue
+		}
+		fmt.Printf("[策略] 命中规则 %q: %s\n", rule.Name, rule.Action)
+		switch rule.Action {
+		case PolicyRoute:
+			return Decision{Accept: true, Dir: rule.Dir}, nil
+		case PolicyAccept:
+			return Decision{Accept: true}, nil
+		default:
+			return Decision{Accept: false}, nil
+		}
+	}
+
+	fmt.Printf("[策略] 未命中任何规则，执行默认动作: %s\n", p.Default)
+	return Decision{Accept: p.Default == PolicyAccept}, nil
+}
+
+// matches 判断请求是否满足所有条件
+func (m *PolicyMatch) matches(req TransferRequest) bool {
+	if len(m.Fingerprints) > 0 && !anyMatch(m.Fingerprints, func(fp string) bool {
+		return fp != "" && strings.HasPrefix(strings.ToLower(req.Sender.Fingerprint), strings.ToLower(fp))
+	}) {
+		return false
+	}
+	if len(m.Aliases) > 0 && !anyMatch(m.Aliases, func(pattern string) bool {
+		ok, _ := path.Match(pattern, req.Sender.Alias)
+		return ok
+	}) {
+		return false
+	}
+	if len(m.subnets) > 0 {
+		ip := remoteIP(req.RemoteAddr)
+		if ip == nil {
+			return false
+		}
+		found := false
+		for _, subnet := range m.subnets {
+			if subnet.Contains(ip) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	count := len(req.Files)
+	if m.MinFiles > 0 && count < m.MinFiles {
+		return false
+	}
+	if m.MaxFiles > 0 && count > m.MaxFiles {
+		return false
+	}
+
+	var total int64
+	for _, f := range req.Files {
+		// 总大小溢出的请求不可能是"小文件"，一律视为不匹配
+		var ok bool
+		if total, ok = addSize(total, f.Size); !ok {
+			return false
+		}
+		if len(m.Extensions) > 0 && !anyMatch(m.Extensions, func(ext string) bool {
+			return strings.EqualFold(filepath.Ext(f.FileName), ext)
+		}) {
+			return false
+		}
+		if len(m.MimeTypes) > 0 && !anyMatch(m.MimeTypes, func(mime string) bool {
+			return mimeMatch(mime, f.FileType)
+		}) {
+			return false
+		}
+	}
+	if m.MaxTotalSize > 0 && total > m.MaxTotalSize {
+		return false
+	}
+	return true
+}
+
+// anyMatch 只要有一个元素满足条件即返回 true
+func anyMatch(list []string, fn func(string) bool) bool {
+	for _, v := range list {
+		if fn(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// mimeMatch 匹配 MIME 类型，支持 "image/*" 形式的通配
+func mimeMatch(pattern, mime string) bool {
+	pattern = strings.ToLower(pattern)
+	mime = strings.ToLower(mime)
+	if prefix, ok := strings.CutSuffix(pattern, "/*"); ok {
+		return strings.HasPrefix(mime, prefix+"/")
+	}
+	return pattern == mime
+}
+
+// remoteIP 从 ip:port 形式的地址中取出 IP
+func remoteIP(addr string) net.IP {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	return net.ParseIP(host)
+}
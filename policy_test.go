@@ -0,0 +1,44 @@
+package main
+
+import (
+	"chrelyonly-localsend-go/model"
+	"math"
+	"testing"
+)
+
+func TestPolicyMaxTotalSize(t *testing.T) {
+	m := PolicyMatch{MaxTotalSize: 10 << 20}
+	half := int64(math.MaxInt64/2 + 1)
+	tests := []struct {
+		sizes []int64
+		want  bool
+	}{
+		{[]int64{1 << 20, 2 << 20}, true},
+		{[]int64{10 << 20}, true},
+		{[]int64{10<<20 + 1}, false},
+		{[]int64{half, half}, false},       // 总和溢出为负数
+		{[]int64{math.MaxInt64, 1}, false}, // 同上
+		{[]int64{math.MaxInt64, math.MaxInt64}, false},
+		{[]int64{-1}, false},
+	}
+	for _, tt := range tests {
+		req := TransferRequest{Files: make(map[string]model.FileDto)}
+		for i, size := range tt.sizes {
+			id := string(rune('a' + i))
+			req.Files[id] = model.FileDto{Id: id, FileName: id + ".bin", Size: size}
+		}
+		if got := m.matches(req); got != tt.want {
+			t.Errorf("大小 %v: matches = %t，应为 %t", tt.sizes, got, tt.want)
+		}
+	}
+
+	// 没有大小上限的规则同样不接受溢出的请求
+	unlimited := PolicyMatch{}
+	req := TransferRequest{Files: map[string]model.FileDto{
+		"a": {Id: "a", FileName: "a.bin", Size: half},
+		"b": {Id: "b", FileName: "b.bin", Size: half},
+	}}
+	if unlimited.matches(req) {
+		t.Error("总大小溢出的请求不应命中任何规则")
+	}
+}
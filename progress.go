@@ -0,0 +1,302 @@
+package main
+
+import (
+	"chrelyonly-localsend-go/model"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ProgressDirection 传输方向
+type ProgressDirection string
+
+const (
+	ProgressSend    ProgressDirection = "send"
+	ProgressReceive ProgressDirection = "receive"
+)
+
+// ProgressEvent 一次进度更新
+// 字节数都是累计值；续传时包含之前已传输的部分。
+type ProgressEvent struct {
+	Direction    ProgressDirection
+	SessionId    string
+	Peer         string        // 对方设备别名
+	File         model.FileDto // 本次更新对应的文件
+	FileBytes    int64         // 该文件已传输的字节数
+	SessionBytes int64         // 会话中所有文件已传输的字节数
+	SessionSize  int64         // 会话中所有文件的总大小
+	FilesDone    int           // 已结束 (成功或失败) 的文件数
+	FilesTotal   int
+	Speed        float64       // 平均速度，字节/秒
+	ETA          time.Duration // 预计剩余时间，速度未知时为 -1
+	FileDone     bool          // 该文件已结束
+	Err          error         // FileDone 时有效，为 nil 表示成功
+}
+
+// SessionDone 会话中所有文件都已结束
+func (ev ProgressEvent) SessionDone() bool {
+	return ev.FilesDone >= ev.FilesTotal
+}
+
+// ProgressListener 接收进度更新
+// 回调可能来自多个 goroutine，实现需要并发安全，且应尽快返回，否则会拖慢传输。
+type ProgressListener interface {
+	OnProgress(ev ProgressEvent)
+}
+
+// ProgressFunc 让普通函数实现 ProgressListener
+type ProgressFunc func(ev ProgressEvent)
+
+func (f ProgressFunc) OnProgress(ev ProgressEvent) {
+	f(ev)
+}
+
+// fileProgress 单个文件的进度
+type fileProgress struct {
+	dto   model.FileDto
+	bytes int64
+	done  bool
+}
+
+// Progress 统计一次会话的传输进度并通知 listener
+// 传输中的更新至多每 ProgressInterval 通知一次；文件结束时总会通知。
+// listener 为 nil 时 NewProgress 返回 nil，nil 的 *Progress 可以安全调用所有方法。
+type Progress struct {
+	listener  ProgressListener
+	direction ProgressDirection
+	sessionId string
+	peer      string
+
+	mu           sync.Mutex
+	files        map[string]*fileProgress
+	sessionBytes int64
+	sessionSize  int64
+	filesDone    int
+	start        time.Time
+	startBytes   int64 // 开始计速时已有的字节数，续传的部分不计入速度
+	lastEmit     time.Time
+}
+
+// NewProgress 为会话中将要传输的文件创建进度统计
+func NewProgress(listener ProgressListener, direction ProgressDirection, sessionId, peer string, files []model.FileDto) *Progress {
+	if listener == nil {
+		return nil
+	}
+	p := &Progress{
+		listener:  listener,
+		direction: direction,
+		sessionId: sessionId,
+		peer:      peer,
+		files:     make(map[string]*fileProgress, len(files)),
+	}
+	for _, f := range files {
+		p.files[f.Id] = &fileProgress{dto: f}
+		p.sessionSize += f.Size
+	}
+	return p
+}
+
+// Reader 包装文件数据流，读取时累计进度
+// offset 为续传起始位置，该文件的进度从 offset 开始计算。
+func (p *Progress) Reader(fileId string, r io.Reader, offset int64) io.Reader {
+	if p == nil {
+		return r
+	}
+	p.mu.Lock()
+	if fp, ok := p.files[fileId]; ok {
+		p.sessionBytes += offset - fp.bytes
+		fp.bytes = offset
+	}
+	if p.start.IsZero() {
+		p.start = time.Now()
+		p.startBytes = p.sessionBytes
+	}
+	p.mu.Unlock()
+	return &progressReader{r: r, p: p, fileId: fileId}
+}
+
+// Done 标记文件结束，err 为 nil 表示成功
+func (p *Progress) Done(fileId string, err error) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	fp, ok := p.files[fileId]
+	if !ok || fp.done {
+		p.mu.Unlock()
+		return
+	}
+	fp.done = true
+	p.filesDone++
+	if err == nil {
+		// 跳过的文件 (skip 策略) 没有实际传输，也按完成计入总量
+		p.sessionBytes += fp.dto.Size - fp.bytes
+		fp.bytes = fp.dto.Size
+	}
+	ev := p.event(fp, time.Now())
+	p.mu.Unlock()
+
+	ev.FileDone = true
+	ev.Err = err
+	p.listener.OnProgress(ev)
+}
+
+// add 累计读取的字节数，到达通知间隔时通知 listener
+func (p *Progress) add(fileId string, n int64) {
+	p.mu.Lock()
+	fp, ok := p.files[fileId]
+	if !ok {
+		p.mu.Unlock()
+		return
+	}
+	fp.bytes += n
+	p.sessionBytes += n
+
+	now := time.Now()
+	if now.Sub(p.lastEmit) < ProgressInterval {
+		p.mu.Unlock()
+		return
+	}
+	p.lastEmit = now
+	ev := p.event(fp, now)
+	p.mu.Unlock()
+
+	p.listener.OnProgress(ev)
+}
+
+// event 生成当前进度，调用方需持有锁
+func (p *Progress) event(fp *fileProgress, now time.Time) ProgressEvent {
+	ev := ProgressEvent{
+		Direction:    p.direction,
+		SessionId:    p.sessionId,
+		Peer:         p.peer,
+		File:         fp.dto,
+		FileBytes:    fp.bytes,
+		SessionBytes: p.sessionBytes,
+		SessionSize:  p.sessionSize,
+		FilesDone:    p.filesDone,
+		FilesTotal:   len(p.files),
+		ETA:          -1,
+	}
+	if elapsed := now.Sub(p.start).Seconds(); !p.start.IsZero() && elapsed > 0 {
+		ev.Speed = float64(p.sessionBytes-p.startBytes) / elapsed
+	}
+	if ev.Speed > 0 {
+		ev.ETA = time.Duration(float64(p.sessionSize-p.sessionBytes) / ev.Speed * float64(time.Second))
+	}
+	return ev
+}
+
+// progressReader 读取时累计进度的 io.Reader
+type progressReader struct {
+	r      io.Reader
+	p      *Progress
+	fileId string
+}
+
+func (r *progressReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	if n > 0 {
+		r.p.add(r.fileId, int64(n))
+	}
+	return n, err
+}
+
+// NewConsoleProgress 创建在终端中显示进度的 listener
+// out 是终端时显示实时刷新的进度条；否则 (重定向到文件、作为服务运行) 每隔
+// ProgressLogInterval 输出一行进度日志，避免日志被控制字符和大量刷新淹没。
+func NewConsoleProgress(out *os.File) ProgressListener {
+	if isTerminal(out) {
+		return &terminalProgress{out: out}
+	}
+	return &logProgress{out: out, last: make(map[string]time.Time)}
+}
+
+// isTerminal 判断 f 是否为终端
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// progressLabel 传输方向的显示名称
+func progressLabel(d ProgressDirection) string {
+	if d == ProgressSend {
+		return "发送"
+	}
+	return "接收"
+}
+
+// terminalProgress 在终端的同一行刷新进度条
+type terminalProgress struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+// progressBarWidth 进度条宽度 (字符数)
+const progressBarWidth = 30
+
+func (t *terminalProgress) OnProgress(ev ProgressEvent) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	ratio := 1.0
+	if ev.SessionSize > 0 {
+		ratio = float64(ev.SessionBytes) / float64(ev.SessionSize)
+	}
+	filled := int(ratio * progressBarWidth)
+	bar := strings.Repeat("#", filled) + strings.Repeat("-", progressBarWidth-filled)
+
+	fmt.Fprintf(t.out, "\r\033[K[%s] [%s] %3.0f%% %s/%s %s/s 剩余 %s (%d/%d 个文件)",
+		progressLabel(ev.Direction), bar, ratio*100,
+		FormatSize(ev.SessionBytes), FormatSize(ev.SessionSize),
+		FormatSize(int64(ev.Speed)), formatETA(ev.ETA), ev.FilesDone, ev.FilesTotal)
+	if ev.SessionDone() {
+		fmt.Fprintln(t.out)
+	}
+}
+
+// logProgress 按固定间隔输出进度日志
+type logProgress struct {
+	mu   sync.Mutex
+	out  io.Writer
+	last map[string]time.Time // key: sessionId
+}
+
+func (l *logProgress) OnProgress(ev ProgressEvent) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	done := ev.SessionDone()
+	if !done && now.Sub(l.last[ev.SessionId]) < ProgressLogInterval {
+		return
+	}
+	if done {
+		delete(l.last, ev.SessionId)
+	} else {
+		l.last[ev.SessionId] = now
+	}
+
+	fmt.Fprintf(l.out, "[进度] %s %s: %s/%s, %d/%d 个文件, %s/s, 剩余 %s\n",
+		progressLabel(ev.Direction), ev.Peer,
+		FormatSize(ev.SessionBytes), FormatSize(ev.SessionSize), ev.FilesDone, ev.FilesTotal,
+		FormatSize(int64(ev.Speed)), formatETA(ev.ETA))
+}
+
+// formatETA 格式化剩余时间，未知时显示 --:--
+func formatETA(d time.Duration) string {
+	if d < 0 {
+		return "--:--"
+	}
+	d = d.Round(time.Second)
+	h := int(d / time.Hour)
+	m := int(d % time.Hour / time.Minute)
+	s := int(d % time.Minute / time.Second)
+	if h > 0 {
+		return fmt.Sprintf("%d:%02d:%02d", h, m, s)
+	}
+	return fmt.Sprintf("%02d:%02d", m, s)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ResolveTarget 将 -target 参数解析为可连接的设备
+// 支持以下几种写法：
+// 1. IP 或 IP:端口，直接连接，不依赖多播发现
+// 2. 设备别名（不区分大小写，完全匹配）
+// 3. 设备指纹前缀
+// 对于后两种，会在 timeout 内等待设备宣告，名称有歧义时返回候选列表。
+func ResolveTarget(registry *PeerRegistry, target string, timeout time.Duration) (Peer, error) {
+	if peer, ok := parseAddrTarget(target); ok {
+		return peer, nil
+	}
+
+	deadline := time.Now().Add(timeout)
+	ticker := time.NewTicker(200 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		matches := matchPeers(registry.List(), target)
+		switch {
+		case len(matches) == 1:
+			peer := matches[0]
+			// 按指纹前缀命中时证书指纹本身即是凭据，无需额外固定
+			if strings.EqualFold(peer.Alias, target) {
+				peer.Target = AliasTarget(target)
+			}
+			return peer, nil
+		case len(matches) > 1:
+			return Peer{}, fmt.Errorf("目标 %q 匹配到多个设备，请使用更长的指纹前缀:\n%s", target, formatPeers(matches))
+		}
+
+		if time.Now().After(deadline) {
+			break
+		}
+		<-ticker.C
+	}
+
+	known := registry.List()
+	if len(known) == 0 {
+		return Peer{}, fmt.Errorf("在 %s 内未发现任何设备，无法解析目标 %q", timeout, target)
+	}
+	return Peer{}, fmt.Errorf("未找到目标 %q，已发现的设备:\n%s", target, formatPeers(known))
+}
+
+// parseAddrTarget 尝试将目标解析为 IP 或 IP:端口
+func parseAddrTarget(target string) (Peer, bool) {
+	host, port := target, DefaultPort
+	if h, p, err := net.SplitHostPort(target); err == nil {
+		n, err := strconv.Atoi(p)
+		if err != nil || n <= 0 || n > 65535 {
+			return Peer{}, false
+		}
+		host, port = h, n
+	}
+	if net.ParseIP(host) == nil {
+		return Peer{}, false
+	}
+	return Peer{
+		IP:       host,
+		Port:     port,
+		Protocol: ProtocolTypeHttpStatus,
+		Target:   AddrTarget(host, port),
+	}, true
+}
+
+// matchPeers 按别名或指纹前缀筛选设备
+// 别名完全匹配优先；没有别名命中时才按指纹前缀匹配。
+func matchPeers(peers []Peer, target string) []Peer {
+	var byAlias, byFingerprint []Peer
+	lower := strings.ToLower(target)
+	for _, p := range peers {
+		if strings.EqualFold(p.Alias, target) {
+			byAlias = append(byAlias, p)
+		} else if strings.HasPrefix(strings.ToLower(p.Fingerprint), lower) {
+			byFingerprint = append(byFingerprint, p)
+		}
+	}
+	if len(byAlias) > 0 {
+		return byAlias
+	}
+	return byFingerprint
+}
+
+// formatPeers 将设备列表格式化为多行文本，用于错误提示
+func formatPeers(peers []Peer) string {
+	var b strings.Builder
+	for _, p := range peers {
+		fmt.Fprintf(&b, "  - %s (%s) %s://%s:%d 指纹 %s\n", p.Alias, p.DeviceModel, p.Protocol, p.IP, p.Port, p.Fingerprint)
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrUnsafePath 对端提供的文件名试图逃出下载目录
+var ErrUnsafePath = errors.New("不安全的文件路径")
+
+// reservedNames 接收端在保存目录中自己使用的名字 (接收清单和隔离目录)，对端提供的路径中不能出现
+// 比较时忽略大小写，因为保存目录可能位于不区分大小写的文件系统上
+var reservedNames = map[string]bool{
+	manifestFileName:  true,
+	QuarantineDirName: true,
+}
+
+// windowsNames 为 true 时额外拒绝 Windows 上无法安全创建的文件名，默认只在 Windows 上开启
+var windowsNames = filepath.Separator == '\\'
+
+// windowsReservedNames Windows 的设备名，无论扩展名是什么都会指向设备而不是文件
+var windowsReservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true, "CONIN$": true, "CONOUT$": true,
+	"COM0": true, "COM1": true, "COM2": true, "COM3": true, "COM4": true,
+	"COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"COM¹": true, "COM²": true, "COM³": true,
+	"LPT0": true, "LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true,
+	"LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+	"LPT¹": true, "LPT²": true, "LPT³": true,
+}
+
+// sanitizeRelPath 校验并规范化对端提供的相对路径，返回各级路径分量
+// 发送方可能是任意平台，因此 / 和 \ 都视为分隔符。以下情况一律拒绝：
+// - 空路径、NUL 及其他控制字符
+// - 绝对路径、UNC 路径 (\\server\share)、盘符 (C:)
+// - 任何 .. 分量
+// - 接收清单和隔离目录等保留名字，避免对端伪造清单记录或写入隔离目录
+// - Windows 上还拒绝设备名 (CON、NUL.txt 等)、以点或空格结尾的分量和 <>:"|?* 字符
+// 空分量和 . 分量会被忽略，例如 "a//./b" 规范化为 a/b。
+func sanitizeRelPath(name string) ([]string, error) {
+	if name == "" {
+		return nil, fmt.Errorf("%w: 文件名为空", ErrUnsafePath)
+	}
+	for _, r := range name {
+		if r < 0x20 || r == 0x7f {
+			return nil, fmt.Errorf("%w: %q 包含控制字符", ErrUnsafePath, name)
+		}
+	}
+
+	normalized := strings.ReplaceAll(name, `\`, "/")
+	if strings.HasPrefix(normalized, "/") {
+		return nil, fmt.Errorf("%w: %q 是绝对路径", ErrUnsafePath, name)
+	}
+	if len(normalized) >= 2 && normalized[1] == ':' && isASCIILetter(normalized[0]) {
+		return nil, fmt.Errorf("%w: %q 包含盘符", ErrUnsafePath, name)
+	}
+
+	var parts []string
+	for _, part := range strings.Split(normalized, "/") {
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			return nil, fmt.Errorf("%w: %q 包含 ..", ErrUnsafePath, name)
+		}
+		if reservedNames[strings.ToLower(part)] {
+			return nil, fmt.Errorf("%w: %q 使用了保留的名字 %s", ErrUnsafePath, name, part)
+		}
+		if windowsNames {
+			if err := checkWindowsName(part); err != nil {
+				return nil, fmt.Errorf("%w: %q %v", ErrUnsafePath, name, err)
+			}
+		}
+		parts = append(parts, part)
+	}
+	if len(parts) == 0 {
+		return nil, fmt.Errorf("%w: %q 不包含文件名", ErrUnsafePath, name)
+	}
+	return parts, nil
+}
+
+// checkWindowsName 检查路径分量在 Windows 上能否安全地作为文件名
+// Windows 会去掉结尾的点和空格 (a.txt. 与 a.txt 是同一个文件)，
+// : 用于盘符和备用数据流 (file.txt:stream)，设备名加任何扩展名仍指向设备。
+func checkWindowsName(part string) error {
+	if i := strings.IndexAny(part, `<>:"|?*`); i >= 0 {
+		return fmt.Errorf("包含 %c", part[i])
+	}
+	if strings.HasSuffix(part, ".") || strings.HasSuffix(part, " ") {
+		return errors.New("以点或空格结尾")
+	}
+	stem, _, _ := strings.Cut(part, ".")
+	if windowsReservedNames[strings.ToUpper(strings.TrimRight(stem, " "))] {
+		return errors.New("是 Windows 设备名")
+	}
+	return nil
+}
+
+// isASCIILetter 判断是否为 ASCII 字母
+func isASCIILetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// SafeCreatePath 在 root 下为对端提供的相对路径 name 创建目录结构，返回文件的保存路径
+// 逐级创建父目录，遇到已存在的符号链接或非目录时拒绝，避免借助符号链接写到 root 之外；
+// 目标文件本身已存在且是符号链接时同样拒绝。
+func SafeCreatePath(root, name string) (string, error) {
+	parts, err := sanitizeRelPath(name)
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return "", err
+	}
+
+	dir := root
+	for _, part := range parts[:len(parts)-1] {
+		dir = filepath.Join(dir, part)
+		fi, err := os.Lstat(dir)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			if err := os.Mkdir(dir, 0755); err != nil && !errors.Is(err, os.ErrExist) {
+				return "", err
+			}
+			// 并发创建时可能被其他请求抢先，重新检查
+			if fi, err = os.Lstat(dir); err != nil {
+				return "", err
+			}
+		case err != nil:
+			return "", err
+		}
+		if fi.Mode()&os.ModeSymlink != 0 || !fi.IsDir() {
+			return "", fmt.Errorf("%w: %s 不是普通目录", ErrUnsafePath, dir)
+		}
+	}
+
+	savePath := filepath.Join(dir, parts[len(parts)-1])
+	if fi, err := os.Lstat(savePath); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+		return "", fmt.Errorf("%w: %s 是符号链接", ErrUnsafePath, savePath)
+	}
+
+	// 兜底检查：结果必须位于 root 之内
+	rel, err := filepath.Rel(root, savePath)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) || filepath.IsAbs(rel) {
+		return "", fmt.Errorf("%w: %q 超出下载目录", ErrUnsafePath, name)
+	}
+	return savePath, nil
+}
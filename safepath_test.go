@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestSanitizeRelPath(t *testing.T) {
+	tests := []struct {
+		name string
+		want []string // nil 表示应当拒绝
+	}{
+		{"a.txt", []string{"a.txt"}},
+		{"dir/sub/a.txt", []string{"dir", "sub", "a.txt"}},
+		{`dir\sub\a.txt`, []string{"dir", "sub", "a.txt"}},
+		{"a//./b", []string{"a", "b"}},
+		{"..", nil},
+		{"a/../../b", nil},
+		{"a/..", nil},
+		{`a\..\b`, nil},
+		{"/abs", nil},
+		{"/etc/passwd", nil},
+		{`\abs`, nil},
+		{`\\server\share`, nil},
+		{`\\?\C:\x`, nil},
+		{"C:x", nil},
+		{`C:\x`, nil},
+		{"c:/x", nil},
+		{"a\x00b", nil},
+		{"a\nb", nil},
+		{"a\x1fb", nil},
+		{"a\x7fb", nil},
+		{"", nil},
+		{".", nil},
+		{"./.", nil},
+		{"//", nil},
+		{".manifest.jsonl", nil},
+		{"sub/.manifest.jsonl", nil},
+		{".MANIFEST.JSONL", nil},
+		{".quarantine/x", nil},
+		{`sub\.Quarantine\x`, nil},
+		{".manifest.jsonl.bak", []string{".manifest.jsonl.bak"}},
+		{"quarantine/x", []string{"quarantine", "x"}},
+	}
+	for _, tt := range tests {
+		got, err := sanitizeRelPath(tt.name)
+		if tt.want == nil {
+			if !errors.Is(err, ErrUnsafePath) {
+				t.Errorf("sanitizeRelPath(%q) = %q, %v; 应返回 ErrUnsafePath", tt.name, got, err)
+			}
+			continue
+		}
+		if err != nil || !slices.Equal(got, tt.want) {
+			t.Errorf("sanitizeRelPath(%q) = %q, %v; 应为 %q", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestSanitizeRelPathWindowsNames(t *testing.T) {
+	names := []string{
+		"CON", "con", "NUL.txt", "dir/aux.tar.gz", "COM1", "lpt9.log", "CON .txt", "COM¹",
+		"a.txt.", "a.txt ", "dir./a.txt",
+		"a:b", "file.txt:stream", "a<b", "a>b", `a"b`, "a|b", "a?b", "a*b",
+	}
+	safe := []string{"CONSOLE.txt", "COM10", "nul_file", "a.txt", ".hidden", "dir/a b.txt"}
+
+	old := windowsNames
+	defer func() { windowsNames = old }()
+
+	windowsNames = true
+	for _, name := range names {
+		if got, err := sanitizeRelPath(name); !errors.Is(err, ErrUnsafePath) {
+			t.Errorf("Windows: sanitizeRelPath(%q) = %q, %v; 应返回 ErrUnsafePath", name, got, err)
+		}
+	}
+	for _, name := range safe {
+		if _, err := sanitizeRelPath(name); err != nil {
+			t.Errorf("Windows: sanitizeRelPath(%q) 返回 %v; 应当接受", name, err)
+		}
+	}
+
+	// 其他平台上这些名字是合法的普通文件名
+	windowsNames = false
+	for _, name := range []string{"CON", "a.txt.", "ab:c"} {
+		if _, err := sanitizeRelPath(name); err != nil {
+			t.Errorf("sanitizeRelPath(%q) 返回 %v; 非 Windows 上应当接受", name, err)
+		}
+	}
+}
+
+func TestSafeCreatePath(t *testing.T) {
+	root := t.TempDir()
+	got, err := SafeCreatePath(root, "a/b/c.txt")
+	if err != nil {
+		t.Fatalf("SafeCreatePath: %v", err)
+	}
+	if want := filepath.Join(root, "a", "b", "c.txt"); got != want {
+		t.Errorf("SafeCreatePath = %q; 应为 %q", got, want)
+	}
+	if fi, err := os.Stat(filepath.Join(root, "a", "b")); err != nil || !fi.IsDir() {
+		t.Errorf("父目录未创建: %v", err)
+	}
+
+	for _, name := range []string{"../x", "/x", `\\server\share\x`, "C:x", `a\..\..\x`} {
+		if _, err := SafeCreatePath(root, name); !errors.Is(err, ErrUnsafePath) {
+			t.Errorf("SafeCreatePath(%q) 返回 %v; 应返回 ErrUnsafePath", name, err)
+		}
+	}
+}
+
+func TestSafeCreatePathSymlinks(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	outsideFile := filepath.Join(outside, "target.txt")
+	if err := os.WriteFile(outsideFile, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "linkdir")); err != nil {
+		t.Skipf("无法创建符号链接: %v", err)
+	}
+	if err := os.Symlink(outsideFile, filepath.Join(root, "link.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "file"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{
+		"linkdir/x.txt",     // 父目录是符号链接
+		"linkdir/sub/x.txt", // 更深的路径同样经过符号链接
+		"link.txt",          // 目标本身是符号链接
+		"file/x.txt",        // 父路径是普通文件
+	} {
+		if _, err := SafeCreatePath(root, name); !errors.Is(err, ErrUnsafePath) {
+			t.Errorf("SafeCreatePath(%q) 返回 %v; 应返回 ErrUnsafePath", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(outside, "sub")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("不应在链接目标中创建目录: %v", err)
+	}
+}
@@ -1,30 +1,59 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"chrelyonly-localsend-go/model"
+	"context"
+	"crypto/sha256"
 	"crypto/tls"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"mime"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/google/uuid"
 )
 
+var (
+	// ErrUnreachable 无法连接到对方
+	ErrUnreachable = errors.New("无法连接对方")
+	// ErrRejected 对方拒绝了传输请求 (403)，或 PIN 校验未通过 (401/429)
+	ErrRejected = errors.New("对方拒绝了请求")
+	// ErrReceiverBusy 对方正在进行其他传输会话 (409)
+	ErrReceiverBusy = errors.New("对方正忙（另一个传输会话进行中），请稍后再试")
+	// ErrIntegrity 对方收到的数据与发送的哈希不符 (422)
+	ErrIntegrity = errors.New("对方校验文件完整性失败")
+	// ErrUploadStalled 上传数据超过 UploadStallTimeout 没有发出去，连接可能已断开
+	ErrUploadStalled = errors.New("上传停滞")
+)
+
 // Sender 负责发送文件
 type Sender struct {
-	client *http.Client
-	info   model.RegisterDto // 自己的信息
+	info  model.RegisterDto // 自己的信息
+	trust *TrustStore       // 已知设备信任库，为 nil 时只校验证书与宣告指纹是否一致
+	pin   string            // 对方要求的接收 PIN，为空时在收到 401 后提示输入
+
+	concurrency int              // 同时上传的文件数
+	progress    ProgressListener // 发送进度的 listener，可为 nil
 }
 
-func NewSender(alias, fingerprint, deviceModel string, port int) *Sender {
+func NewSender(alias, fingerprint, deviceModel string, port int, trust *TrustStore) *Sender {
 	return &Sender{
-		client: &http.Client{
-			Timeout: ConnectTimeout,
-		},
+		trust:       trust,
+		concurrency: DefaultUploadConcurrency,
 		info: model.RegisterDto{
 			Alias:       alias,
 			Version:     ProtocolVersion,
@@ -38,94 +67,679 @@ func NewSender(alias, fingerprint, deviceModel string, port int) *Sender {
 	}
 }
 
-// SendFile 发送文件给目标设备
-func (s *Sender) SendFile(targetIP string, targetPort int, filePath string) error {
-	file, err := os.Open(filePath)
-	if err != nil {
-		return fmt.Errorf("打开文件失败: %v", err)
+// SetPin 设置发送时携带的 PIN
+func (s *Sender) SetPin(pin string) {
+	s.pin = pin
+}
+
+// SetConcurrency 设置同时上传的文件数，小于 1 时按 1 处理
+func (s *Sender) SetConcurrency(n int) {
+	if n < 1 {
+		n = 1
 	}
-	defer file.Close()
+	s.concurrency = n
+}
+
+// SetProgressListener 设置发送进度的 listener
+func (s *Sender) SetProgressListener(l ProgressListener) {
+	s.progress = l
+}
+
+// localFile 待发送的本地文件
+type localFile struct {
+	path string        // 本地路径
+	data []byte        // 内存中的内容 (文本消息)，非 nil 时不读取 path
+	dto  model.FileDto // 发给对方的元数据
+}
+
+// open 打开文件内容以供上传
+func (f localFile) open() (io.ReadSeekCloser, error) {
+	if f.data != nil {
+		return nopSeekCloser{bytes.NewReader(f.data)}, nil
+	}
+	return os.Open(f.path)
+}
+
+// nopSeekCloser 为 io.ReadSeeker 加上空的 Close
+type nopSeekCloser struct {
+	io.ReadSeeker
+}
+
+func (nopSeekCloser) Close() error { return nil }
 
-	fileStat, err := file.Stat()
+// collectFiles 收集待发送的文件
+// 普通文件使用文件名；目录会被递归遍历，文件名为包含目录名在内的相对路径 (如 photos/2024/a.jpg)，
+// 与 LocalSend 发送文件夹时的做法一致，接收方据此还原目录结构。
+// 每个文件都会预先计算 SHA-256 填入 Hash，供接收方校验完整性。
+func collectFiles(paths []string) ([]localFile, error) {
+	var files []localFile
+	add := func(path, name string, size int64) error {
+		hash, err := hashFile(path)
+		if err != nil {
+			return fmt.Errorf("计算 %s 的哈希失败: %v", path, err)
+		}
+		fileId := uuid.New().String()
+		fileType := mime.TypeByExtension(filepath.Ext(name))
+		if fileType == "" {
+			fileType = "application/octet-stream"
+		}
+		files = append(files, localFile{
+			path: path,
+			dto: model.FileDto{
+				Id:       fileId,
+				FileName: name,
+				Size:     size,
+				FileType: fileType,
+				Hash:     hash,
+			},
+		})
+		return nil
+	}
+
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			return nil, fmt.Errorf("获取文件信息失败: %v", err)
+		}
+		if !info.IsDir() {
+			if err := add(p, filepath.Base(p), info.Size()); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		// 相对路径以目录的上一级为基准，保留目录名本身
+		base := filepath.Dir(filepath.Clean(p))
+		err = filepath.WalkDir(p, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				return nil
+			}
+			info, err := os.Stat(path)
+			if err != nil {
+				return err
+			}
+			if !info.Mode().IsRegular() {
+				fmt.Printf("[发送端] 跳过非普通文件: %s\n", path)
+				return nil
+			}
+			rel, err := filepath.Rel(base, path)
+			if err != nil {
+				return err
+			}
+			return add(path, filepath.ToSlash(rel), info.Size())
+		})
+		if err != nil {
+			return nil, fmt.Errorf("遍历目录 %s 失败: %v", p, err)
+		}
+	}
+
+	if len(files) == 0 {
+		return nil, fmt.Errorf("没有可发送的文件")
+	}
+	return files, nil
+}
+
+// SendFiles 在一个传输会话中发送多个文件或目录给目标设备
+// target 的 IP、端口和协议通常来自设备表 (见 ResolveTarget)
+// ctx 结束 (如 Ctrl-C 或超时) 时中止所有上传，并通知对方取消会话，对方会删除未接收完的文件。
+func (s *Sender) SendFiles(ctx context.Context, target Peer, paths []string) error {
+	files, err := collectFiles(paths)
 	if err != nil {
-		return fmt.Errorf("获取文件信息失败: %v", err)
+		return err
 	}
+	return s.send(ctx, target, files)
+}
 
+// SendText 发送一条文本消息
+// 与 LocalSend 一致，消息作为一个 text/plain 文件发送，内容放在 preview 中；
+// 对方直接显示消息并返回 204。对方不支持文本消息而发放了 Token 时，把消息作为 .txt 文件上传。
+func (s *Sender) SendText(ctx context.Context, target Peer, text string) error {
+	data := []byte(text)
+	sum := sha256.Sum256(data)
 	fileId := uuid.New().String()
-	fileName := filepath.Base(filePath)
-	fileSize := fileStat.Size()
+	msg := localFile{
+		data: data,
+		dto: model.FileDto{
+			Id:       fileId,
+			FileName: fileId + ".txt",
+			Size:     int64(len(data)),
+			FileType: "text/plain",
+			Hash:     hex.EncodeToString(sum[:]),
+			Preview:  text,
+		},
+	}
+	return s.send(ctx, target, []localFile{msg})
+}
 
+// send 在一个传输会话中发送 files
+func (s *Sender) send(ctx context.Context, target Peer, files []localFile) error {
 	// 1. Prepare Upload
-	files := make(map[string]model.FileDto)
-	files[fileId] = model.FileDto{
-		Id:       fileId,
-		FileName: fileName,
-		Size:     fileSize,
-		FileType: "application/octet-stream", // 简化
+	dtos := make(map[string]model.FileDto, len(files))
+	for _, f := range files {
+		dtos[f.dto.Id] = f.dto
 	}
-
 	reqDto := model.PrepareUploadRequestDto{
 		Info:  s.info,
-		Files: files,
+		Files: dtos,
 	}
 
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	// 校验对方身份，得到只信任其证书的客户端
+	client, err := s.connect(ctx, &target)
+	if err != nil {
+		return err
 	}
-	client := &http.Client{Transport: tr}
 
 	reqBody, _ := json.Marshal(reqDto)
-	targetUrl := fmt.Sprintf("https://%s:%d/api/localsend/v2/prepare-upload", targetIP, targetPort)
+	prepareResp, resumable, err := s.prepareUpload(ctx, client, target, reqBody)
+	if err != nil {
+		return err
+	}
+	// 204: 对方已直接收下 (如文本消息)，无需上传
+	if prepareResp == nil {
+		fmt.Println("[发送端] 对方已收到，无需上传")
+		return nil
+	}
+	t := &transfer{
+		client:    client,
+		target:    target,
+		sessionId: prepareResp.SessionId,
+		resumable: resumable,
+	}
 
-	fmt.Printf("[发送端] 正在发送准备上传请求至 %s\n", targetUrl)
-	resp, err := client.Post(targetUrl, "application/json", bytes.NewBuffer(reqBody))
+	// 2. Upload Files
+	// 每个文件使用各自的 Token 上传；对方只接受了部分文件时跳过其余文件
+	var accepted, declined []localFile
+	for _, f := range files {
+		if _, ok := prepareResp.Files[f.dto.Id]; !ok {
+			fmt.Printf("[发送端] 对方未接受文件 %s，已跳过\n", f.dto.FileName)
+			declined = append(declined, f)
+			continue
+		}
+		accepted = append(accepted, f)
+	}
+
+	acceptedDtos := make([]model.FileDto, len(accepted))
+	for i, f := range accepted {
+		acceptedDtos[i] = f.dto
+	}
+	t.progress = NewProgress(s.progress, ProgressSend, t.sessionId, target.Alias, acceptedDtos)
+
+	results := s.uploadAll(ctx, t, accepted, prepareResp.Files)
+	if err := ctx.Err(); err != nil {
+		s.cancelSession(t)
+		summarize(results, declined)
+		return fmt.Errorf("发送已取消: %w", err)
+	}
+	return summarize(results, declined)
+}
+
+// cancelSession 通知对方取消会话
+// 发送时的 ctx 已经结束，这里使用单独的超时
+func (s *Sender) cancelSession(t *transfer) {
+	ctx, cancel := context.WithTimeout(context.Background(), ConnectTimeout)
+	defer cancel()
+
+	cancelUrl := fmt.Sprintf("%s/api/localsend/v2/cancel?sessionId=%s", peerBaseUrl(t.target), url.QueryEscape(t.sessionId))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cancelUrl, nil)
 	if err != nil {
-		return fmt.Errorf("准备上传失败: %v", err)
+		return
 	}
-	defer resp.Body.Close()
+	resp, err := t.client.Do(req)
+	if err != nil {
+		fmt.Printf("[发送端] 通知对方取消会话失败: %v\n", err)
+		return
+	}
+	resp.Body.Close()
+	fmt.Printf("[发送端] 已通知对方取消会话 %s\n", t.sessionId)
+}
 
-	if resp.StatusCode != http.StatusOK {
-		// 读取错误信息
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("准备上传请求被拒绝 (状态码 %d): %s", resp.StatusCode, string(bodyBytes))
+// uploadResult 单个文件的上传结果
+type uploadResult struct {
+	file localFile
+	err  error
+}
+
+// uploadAll 用至多 s.concurrency 个 worker 并发上传文件
+// 单个文件失败不影响其他文件，结果按 files 的顺序返回；ctx 结束后不再开始新的上传
+func (s *Sender) uploadAll(ctx context.Context, t *transfer, files []localFile, tokens map[string]string) []uploadResult {
+	results := make([]uploadResult, len(files))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+
+	workers := min(s.concurrency, len(files))
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				f := files[i]
+				err := ctx.Err()
+				if err == nil {
+					err = s.uploadFile(ctx, t, f, tokens[f.dto.Id])
+				}
+				t.progress.Done(f.dto.Id, err)
+				results[i] = uploadResult{file: f, err: err}
+			}
+		}()
+	}
+	for i := range files {
+		jobs <- i
 	}
+	close(jobs)
+	wg.Wait()
+	return results
+}
 
-	var prepareResp model.PrepareUploadResponseDto
-	if err := json.NewDecoder(resp.Body).Decode(&prepareResp); err != nil {
-		return fmt.Errorf("解析响应失败: %v", err)
+// summarize 打印发送结果汇总，declined 为对方未接受的文件，有文件失败时返回汇总错误
+// 对方一个文件都没有接受时返回 ErrRejected
+// 返回的错误包装了各文件的原始错误，调用方可以用 errors.Is 判断 ErrIntegrity 等
+func summarize(results []uploadResult, declined []localFile) error {
+	var succeeded []string
+	var errs []error
+	for _, r := range results {
+		if r.err != nil {
+			errs = append(errs, r.err)
+			continue
+		}
+		succeeded = append(succeeded, r.file.dto.FileName)
+	}
+
+	total := len(results) + len(declined)
+	fmt.Printf("[发送端] 发送完成: 成功 %d 个，失败 %d 个，未接受 %d 个，共 %d 个文件\n", len(succeeded), len(errs), len(declined), total)
+	for _, name := range succeeded {
+		fmt.Printf("  [成功] %s\n", name)
+	}
+	for _, r := range results {
+		if r.err != nil {
+			fmt.Printf("  [失败] %s: %v\n", r.file.dto.FileName, r.err)
+		}
+	}
+	for _, f := range declined {
+		fmt.Printf("  [未接受] %s\n", f.dto.FileName)
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%d 个文件发送失败: %w", len(errs), errors.Join(errs...))
+	}
+	if len(succeeded) == 0 && len(declined) > 0 {
+		return fmt.Errorf("%w: 对方未接受任何文件", ErrRejected)
+	}
+	return nil
+}
+
+// transfer 一次发送会话的上下文
+type transfer struct {
+	client    *http.Client
+	target    Peer
+	sessionId string
+	resumable bool      // 对方支持续传扩展
+	progress  *Progress // 发送进度，可为 nil
+}
+
+// uploadFile 上传单个文件
+// 对方支持续传时，连接中断后查询对方已接收的字节数并从该位置继续，最多 ResumeAttempts 次；
+// 对方不支持时与标准 LocalSend 一致，中断即失败。
+func (s *Sender) uploadFile(ctx context.Context, t *transfer, f localFile, token string) error {
+	fmt.Printf("[发送端] 正在上传文件: %s\n", f.dto.FileName)
+
+	// 查询续传位置本身失败 (连接仍未恢复或对方正在接管旧的上传) 时同样计入重试次数
+	err := s.uploadFrom(ctx, t, f, token, 0)
+	for attempt := 1; err != nil; attempt++ {
+		if !t.resumable || attempt > ResumeAttempts || !errors.Is(err, ErrUploadInterrupted) {
+			return err
+		}
+
+		fmt.Printf("[发送端] %v，%d 秒后尝试续传 (%d/%d)\n", err, attempt, attempt, ResumeAttempts)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Duration(attempt) * time.Second):
+		}
+		var offset int64
+		if offset, err = s.queryOffset(ctx, t, f, token); err == nil {
+			fmt.Printf("[发送端] 从 %d 字节处续传 %s\n", offset, f.dto.FileName)
+			err = s.uploadFrom(ctx, t, f, token, offset)
+		}
+	}
+	fmt.Printf("[发送端] 文件发送成功: %s\n", f.dto.FileName)
+	return nil
+}
+
+// uploadFrom 从 offset 处开始上传文件的剩余部分
+func (s *Sender) uploadFrom(ctx context.Context, t *transfer, f localFile, token string, offset int64) error {
+	file, err := f.open()
+	if err != nil {
+		return fmt.Errorf("打开文件失败: %v", err)
 	}
+	defer file.Close()
 
-	token, ok := prepareResp.Files[fileId]
-	if !ok {
-		return fmt.Errorf("服务器未返回文件 Token")
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf("定位文件失败: %v", err)
 	}
 
-	// 2. Upload File
-	uploadUrl := fmt.Sprintf("https://%s:%d/api/localsend/v2/upload?sessionId=%s&fileId=%s&token=%s",
-		targetIP, targetPort, prepareResp.SessionId, fileId, token)
+	uploadUrl := fmt.Sprintf("%s/api/localsend/v2/upload?sessionId=%s&fileId=%s&token=%s",
+		peerBaseUrl(t.target), url.QueryEscape(t.sessionId), url.QueryEscape(f.dto.Id), url.QueryEscape(token))
+	if offset > 0 {
+		uploadUrl += "&offset=" + strconv.FormatInt(offset, 10)
+	}
 
-	fmt.Printf("[发送端] 正在上传文件至 %s\n", uploadUrl)
+	// 连接断开但没有收到 RST 时，写入会一直阻塞到 TCP 重传超时；
+	// 请求体超过 UploadStallTimeout 没有被读取就取消请求，转为续传
+	reqCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	body := newStallReader(t.progress.Reader(f.dto.Id, file, offset), UploadStallTimeout, func() { cancel(ErrUploadStalled) })
+	defer body.stop()
 
 	// 由于是二进制流上传，直接把 file 作为 Body
 	// 注意：LocalSend v2 upload 接口直接接收 binary stream，不需要 multipart
-	uploadReq, err := http.NewRequest("POST", uploadUrl, file)
+	uploadReq, err := http.NewRequestWithContext(reqCtx, "POST", uploadUrl, body)
 	if err != nil {
 		return fmt.Errorf("创建上传请求失败: %v", err)
 	}
 	uploadReq.Header.Set("Content-Type", "application/octet-stream")
-	uploadReq.Header.Set("Content-Length", fmt.Sprintf("%d", fileSize))
+	uploadReq.ContentLength = f.dto.Size - offset
 
-	uploadResp, err := client.Do(uploadReq)
+	uploadResp, err := t.client.Do(uploadReq)
 	if err != nil {
-		return fmt.Errorf("上传失败: %v", err)
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if errors.Is(err, ErrFingerprintMismatch) {
+			return fmt.Errorf("上传 %s 失败: %w", f.dto.FileName, err)
+		}
+		if cause := context.Cause(reqCtx); errors.Is(cause, ErrUploadStalled) {
+			err = fmt.Errorf("%w: %s 内没有发出数据", cause, UploadStallTimeout)
+		}
+		return fmt.Errorf("上传 %s 失败: %w: %w", f.dto.FileName, ErrUploadInterrupted, err)
 	}
 	defer uploadResp.Body.Close()
 
+	switch uploadResp.StatusCode {
+	case http.StatusUnprocessableEntity:
+		return fmt.Errorf("上传 %s: %w", f.dto.FileName, ErrIntegrity)
+	case http.StatusServiceUnavailable:
+		// 对方还在等待上一次上传退出，稍后续传
+		return fmt.Errorf("上传 %s: %w: 对方仍在处理上一次上传", f.dto.FileName, ErrUploadInterrupted)
+	}
 	if uploadResp.StatusCode != http.StatusOK {
 		bodyBytes, _ := io.ReadAll(uploadResp.Body)
-		return fmt.Errorf("上传请求被拒绝 (状态码 %d): %s", uploadResp.StatusCode, string(bodyBytes))
+		return fmt.Errorf("上传 %s 被拒绝 (状态码 %d): %s", f.dto.FileName, uploadResp.StatusCode, string(bodyBytes))
 	}
-
-	fmt.Printf("[发送端] 文件发送成功!\n")
 	return nil
 }
+
+// queryOffset 查询对方已接收的字节数 (续传扩展)
+func (s *Sender) queryOffset(ctx context.Context, t *transfer, f localFile, token string) (int64, error) {
+	offsetUrl := fmt.Sprintf("%s%s?sessionId=%s&fileId=%s&token=%s",
+		peerBaseUrl(t.target), ResumeOffsetPath, url.QueryEscape(t.sessionId), url.QueryEscape(f.dto.Id), url.QueryEscape(token))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, offsetUrl, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return 0, ctxErr
+		}
+		return 0, fmt.Errorf("获取 %s 的续传位置失败: %w: %w", f.dto.FileName, ErrUploadInterrupted, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusServiceUnavailable {
+		return 0, fmt.Errorf("获取 %s 的续传位置失败: %w: 对方仍在处理上一次上传", f.dto.FileName, ErrUploadInterrupted)
+	}
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return 0, fmt.Errorf("上传 %s 中断，且无法获取续传位置: 状态码 %d: %s", f.dto.FileName, resp.StatusCode, string(bodyBytes))
+	}
+	var dto model.UploadOffsetDto
+	if err := json.NewDecoder(resp.Body).Decode(&dto); err != nil {
+		return 0, fmt.Errorf("解析续传位置失败: %v", err)
+	}
+	if dto.Offset < 0 || dto.Offset > f.dto.Size {
+		return 0, fmt.Errorf("续传位置 %d 超出文件大小 %d", dto.Offset, f.dto.Size)
+	}
+	return dto.Offset, nil
+}
+
+// stallReader 请求体读取器：超过 timeout 没有被读取时调用 onStall
+// 读到结尾后停止计时，之后等待响应 (对方校验哈希、落盘) 的时间不受限制
+type stallReader struct {
+	r     io.Reader
+	timer *time.Timer
+	limit time.Duration
+}
+
+// newStallReader 包装 r，从现在开始计时
+func newStallReader(r io.Reader, limit time.Duration, onStall func()) *stallReader {
+	return &stallReader{r: r, timer: time.AfterFunc(limit, onStall), limit: limit}
+}
+
+func (sr *stallReader) Read(p []byte) (int, error) {
+	n, err := sr.r.Read(p)
+	if err != nil {
+		sr.stop()
+	} else {
+		sr.timer.Reset(sr.limit)
+	}
+	return n, err
+}
+
+// stop 停止计时
+func (sr *stallReader) stop() {
+	sr.timer.Stop()
+}
+
+// prepareUpload 发送 prepare-upload 请求，并返回对方是否支持续传扩展
+// 对方要求 PIN (401) 时提示用户输入并重试，最多 PinPromptAttempts 次。
+// 对方返回 204 (已直接收下，无需上传) 时响应为 nil。
+func (s *Sender) prepareUpload(ctx context.Context, client *http.Client, target Peer, reqBody []byte) (*model.PrepareUploadResponseDto, bool, error) {
+	for attempt := 0; ; attempt++ {
+		targetUrl := fmt.Sprintf("%s/api/localsend/v2/prepare-upload", peerBaseUrl(target))
+		if s.pin != "" {
+			targetUrl += "?pin=" + url.QueryEscape(s.pin)
+		}
+
+		fmt.Printf("[发送端] 正在发送准备上传请求至 %s\n", peerBaseUrl(target))
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, targetUrl, bytes.NewReader(reqBody))
+		if err != nil {
+			return nil, false, fmt.Errorf("创建准备上传请求失败: %v", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		// 告知接收方本机会在连接中断后续传，接收方据此保留已接收的数据
+		req.Header.Set(ResumeHeader, "1")
+		resp, err := client.Do(req)
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return nil, false, ctxErr
+			}
+			return nil, false, fmt.Errorf("准备上传失败: %w", unreachable(err))
+		}
+
+		if resp.StatusCode == http.StatusUnauthorized && attempt < PinPromptAttempts {
+			resp.Body.Close()
+			if s.pin != "" {
+				fmt.Println("[发送端] PIN 错误")
+			}
+			pin, err := promptPin(ctx)
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return nil, false, ctxErr
+			}
+			if err != nil {
+				return nil, false, fmt.Errorf("%w: 对方需要 PIN: %v", ErrRejected, err)
+			}
+			s.pin = pin
+			continue
+		}
+
+		defer resp.Body.Close()
+		switch resp.StatusCode {
+		case http.StatusOK:
+		case http.StatusNoContent:
+			return nil, false, nil
+		case http.StatusUnauthorized:
+			return nil, false, fmt.Errorf("%w: PIN 错误", ErrRejected)
+		case http.StatusForbidden:
+			return nil, false, fmt.Errorf("%w: 对方拒绝了本次传输", ErrRejected)
+		case http.StatusConflict:
+			return nil, false, ErrReceiverBusy
+		case http.StatusTooManyRequests:
+			return nil, false, fmt.Errorf("%w: PIN 错误次数过多，对方暂时拒绝了来自本机的请求", ErrRejected)
+		default:
+			// 读取错误信息
+			bodyBytes, _ := io.ReadAll(resp.Body)
+			return nil, false, fmt.Errorf("准备上传请求被拒绝 (状态码 %d): %s", resp.StatusCode, string(bodyBytes))
+		}
+
+		var prepareResp model.PrepareUploadResponseDto
+		if err := json.NewDecoder(resp.Body).Decode(&prepareResp); err != nil {
+			return nil, false, fmt.Errorf("解析响应失败: %v", err)
+		}
+		return &prepareResp, resp.Header.Get(ResumeHeader) == "1", nil
+	}
+}
+
+// stdinReader 共享的标准输入读取器，多次提示时不会丢失已缓冲的输入
+var stdinReader = bufio.NewReader(os.Stdin)
+
+// promptPin 在终端中提示输入 PIN，ctx 结束时不再等待输入
+func promptPin(ctx context.Context) (string, error) {
+	fmt.Print("[发送端] 对方需要 PIN，请输入: ")
+	type result struct {
+		line string
+		err  error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		line, err := stdinReader.ReadString('\n')
+		ch <- result{line, err}
+	}()
+
+	var r result
+	select {
+	case <-ctx.Done():
+		fmt.Println()
+		return "", ctx.Err()
+	case r = <-ch:
+	}
+	line, err := r.line, r.err
+	pin := strings.TrimSpace(line)
+	if pin == "" {
+		if err == nil {
+			err = fmt.Errorf("未输入 PIN")
+		}
+		return "", err
+	}
+	return pin, nil
+}
+
+// connect 校验目标设备身份，返回固定信任其证书的 HTTP 客户端
+//  1. 目标来自多播时，宣告中已带有指纹；直接输入 IP 时，先请求 /info 获取指纹，
+//     并要求 TLS 证书指纹与 /info 返回的指纹一致。
+//  2. 用信任库按首次使用即信任的策略校验用户指定的目标 (地址或别名) 与指纹的对应关系。
+//  3. 之后的每个连接都会重新比对证书指纹，防止中途被替换。
+func (s *Sender) connect(ctx context.Context, target *Peer) (*http.Client, error) {
+	if target.Protocol == ProtocolTypeHttp {
+		fmt.Printf("[发送端] 警告: 对方使用 HTTP，无法校验设备身份\n")
+		return &http.Client{}, nil
+	}
+
+	if target.Fingerprint == "" {
+		info, err := FetchInfo(ctx, *target)
+		if err != nil {
+			return nil, err
+		}
+		target.Fingerprint = info.Fingerprint
+		target.Alias = info.Alias
+	}
+
+	if s.trust != nil {
+		if err := s.trust.Verify(target.Target, target.Alias, target.Fingerprint); err != nil {
+			return nil, err
+		}
+	}
+	return pinnedClient(target.Fingerprint), nil
+}
+
+// FetchInfo 请求对方的 /info 接口；使用 HTTPS 时校验其证书指纹与返回的指纹一致
+// ctx 结束 (Ctrl-C 或 -timeout) 时立即返回 ctx 的错误，不必等到 ConnectTimeout。
+func FetchInfo(ctx context.Context, target Peer) (*model.InfoDto, error) {
+	client := pinnedClient("")
+	client.Timeout = ConnectTimeout
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/api/localsend/v2/info", peerBaseUrl(target)), nil)
+	if err != nil {
+		return nil, fmt.Errorf("创建设备信息请求失败: %v", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, fmt.Errorf("获取设备信息失败: %w", unreachable(err))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("获取设备信息失败 (状态码 %d)", resp.StatusCode)
+	}
+	var info model.InfoDto
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return nil, fmt.Errorf("解析设备信息失败: %v", err)
+	}
+
+	if target.Protocol == ProtocolTypeHttp {
+		return &info, nil
+	}
+	if resp.TLS == nil || len(resp.TLS.PeerCertificates) == 0 {
+		return nil, fmt.Errorf("对方未提供 TLS 证书")
+	}
+	actual := CertFingerprint(resp.TLS.PeerCertificates[0].Raw)
+	if info.Fingerprint != actual {
+		return nil, fmt.Errorf("%w: 对方证书指纹为 %s，但声称的指纹为 %s", ErrFingerprintMismatch, actual, info.Fingerprint)
+	}
+	return &info, nil
+}
+
+// unreachable 把连接错误包装为 ErrUnreachable，证书指纹不符的错误保持原样
+func unreachable(err error) error {
+	if errors.Is(err, ErrFingerprintMismatch) {
+		return err
+	}
+	return fmt.Errorf("%w: %w", ErrUnreachable, err)
+}
+
+// pinnedClient 返回只接受指定证书指纹的 HTTP 客户端
+// LocalSend 使用自签名证书，无法走 CA 校验，因此跳过证书链验证，改为比对叶子证书指纹。
+// expected 为空时不做比对，由调用方自行检查 resp.TLS。
+func pinnedClient(expected string) *http.Client {
+	tr := &http.Transport{
+		TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: true,
+			VerifyConnection: func(cs tls.ConnectionState) error {
+				if len(cs.PeerCertificates) == 0 {
+					return fmt.Errorf("对方未提供 TLS 证书")
+				}
+				actual := CertFingerprint(cs.PeerCertificates[0].Raw)
+				if expected != "" && actual != expected {
+					return fmt.Errorf("%w: 对方证书指纹为 %s，预期为 %s", ErrFingerprintMismatch, actual, expected)
+				}
+				return nil
+			},
+		},
+	}
+	return &http.Client{Transport: tr}
+}
+
+// peerBaseUrl 返回设备 HTTP 服务的根地址，例如 https://192.168.1.2:53317
+func peerBaseUrl(peer Peer) string {
+	protocol := peer.Protocol
+	if protocol == "" {
+		protocol = ProtocolTypeHttpStatus
+	}
+	return fmt.Sprintf("%s://%s", protocol, net.JoinHostPort(peer.IP, strconv.Itoa(peer.Port)))
+}
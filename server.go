@@ -2,15 +2,20 @@ package main
 
 import (
 	"chrelyonly-localsend-go/model"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"mime"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
-
-	"github.com/google/uuid"
+	"strconv"
+	"sync/atomic"
+	"time"
 )
 
 // FileServer 实现 LocalSend 的 HTTP 协议服务端
@@ -21,30 +26,132 @@ type FileServer struct {
 	fingerprint string
 	deviceModel string
 
-	// sessions 存储当前的传输会话状态
-	// key: sessionId
-	sessions map[string]*Session
-}
+	// TLS 证书和私钥路径，来自设备身份所在的状态目录
+	certFile string
+	keyFile  string
+
+	// sessions 管理当前的传输会话状态
+	sessions *SessionManager
+
+	// acceptor 决定是否接受传输请求，acceptTimeout 为等待决定的最长时间
+	acceptor      AcceptHandler
+	acceptTimeout time.Duration
+
+	// pins 校验 prepare-upload 的 pin 参数，未设置 PIN 时不校验
+	pins *PinGuard
+
+	// conflict 接收文件与已有文件重名时的处理策略
+	conflict ConflictStrategy
 
-// Session 代表一次传输会话
-type Session struct {
-	Id     string
-	Files  map[string]model.FileDto // 待接收的文件信息
-	Tokens map[string]string        // 每个文件的上传鉴权 Token
+	// 单个文件和单次会话的大小上限（字节），0 表示不限制
+	maxFileSize    int64
+	maxSessionSize int64
+
+	// downloadDir 接收文件的保存目录，会话指定的相对目录基于此目录
+	downloadDir string
+
+	// quarantine 为 true 时，哈希校验失败的文件移入隔离目录而不是直接删除
+	quarantine bool
+
+	// texts 处理收到的文本消息
+	texts TextSink
+
+	// share 下载模式下发布的文件，为 nil 表示未开启下载模式
+	share atomic.Pointer[Share]
 }
 
-func NewFileServer(port int, alias, fingerprint, deviceModel string) *FileServer {
+// ErrInvalidSize 文件声明的大小无效
+var ErrInvalidSize = errors.New("文件大小无效")
+
+// NewFileServer 创建服务端，maxSessions 为同时进行的会话上限 (0 表示不限制)
+func NewFileServer(port int, alias, fingerprint, deviceModel, certFile, keyFile string, maxSessions int) *FileServer {
 	return &FileServer{
 		port:        port,
 		alias:       alias,
 		fingerprint: fingerprint,
 		deviceModel: deviceModel,
-		sessions:    make(map[string]*Session),
+		certFile:    certFile,
+		keyFile:     keyFile,
+		sessions:    NewSessionManager(SessionIdleTimeout, maxSessions),
+
+		acceptor:      AutoAccept,
+		acceptTimeout: DefaultAcceptTimeout,
+		conflict:      ConflictRename,
+		downloadDir:   DownloadDir,
+		texts:         NewWriterTextSink(os.Stdout),
+	}
+}
+
+// SetLimits 设置单个文件和单次会话的大小上限，0 表示不限制
+func (s *FileServer) SetLimits(maxFileSize, maxSessionSize int64) {
+	s.maxFileSize = maxFileSize
+	s.maxSessionSize = maxSessionSize
+}
+
+// checkLimits 检查 prepare-upload 中声明的文件大小是否在上限之内
+func (s *FileServer) checkLimits(files map[string]model.FileDto) error {
+	var total int64
+	for _, f := range files {
+		if f.Size < 0 {
+			return fmt.Errorf("%w: %s (%d)", ErrInvalidSize, f.FileName, f.Size)
+		}
+		if s.maxFileSize > 0 && f.Size > s.maxFileSize {
+			return fmt.Errorf("文件 %s (%s) 超过单文件上限 %s", f.FileName, FormatSize(f.Size), FormatSize(s.maxFileSize))
+		}
+		var ok bool
+		if total, ok = addSize(total, f.Size); !ok {
+			return errors.New("文件总大小超出范围")
+		}
+	}
+	if s.maxSessionSize > 0 && total > s.maxSessionSize {
+		return fmt.Errorf("总大小 %s 超过单次会话上限 %s", FormatSize(total), FormatSize(s.maxSessionSize))
 	}
+	return nil
+}
+
+// SetDownloadDir 设置接收文件的保存目录
+func (s *FileServer) SetDownloadDir(dir string) {
+	s.downloadDir = dir
+}
+
+// SetQuarantine 设置哈希校验失败时是否隔离文件
+func (s *FileServer) SetQuarantine(enabled bool) {
+	s.quarantine = enabled
+}
+
+// SetProgressListener 设置接收进度的 listener
+func (s *FileServer) SetProgressListener(l ProgressListener) {
+	s.sessions.SetProgressListener(l)
+}
+
+// SetTextSink 设置文本消息的处理方式，默认打印到标准输出
+func (s *FileServer) SetTextSink(sink TextSink) {
+	s.texts = sink
+}
+
+// SetShare 开启下载模式并发布 sh 中的文件，sh 为 nil 时关闭下载模式
+func (s *FileServer) SetShare(sh *Share) {
+	s.share.Store(sh)
+}
+
+// SetConflictStrategy 设置重名处理策略，默认追加序号
+func (s *FileServer) SetConflictStrategy(strategy ConflictStrategy) {
+	s.conflict = strategy
+}
+
+// SetPin 设置接收 PIN，为空表示不需要 PIN
+func (s *FileServer) SetPin(pin string) {
+	s.pins = NewPinGuard(pin, PinMaxFailures, PinLockout)
+}
+
+// SetAcceptHandler 设置传输请求的确认方式，默认自动接受
+func (s *FileServer) SetAcceptHandler(h AcceptHandler, timeout time.Duration) {
+	s.acceptor = h
+	s.acceptTimeout = timeout
 }
 
-// Start 启动 HTTP 服务器
-func (s *FileServer) Start() {
+// Start 启动 HTTP 服务器，阻塞运行，只在监听失败时返回
+func (s *FileServer) Start() error {
 	mux := http.NewServeMux()
 
 	// 注册 v2 协议路由
@@ -58,6 +165,16 @@ func (s *FileServer) Start() {
 	mux.HandleFunc("/api/localsend/v2/upload", s.handleUpload)
 	// 5. 取消传输
 	mux.HandleFunc("/api/localsend/v2/cancel", s.handleCancel)
+	// 6. 查询续传位置 (扩展，标准 LocalSend 客户端不会调用)
+	mux.HandleFunc(ResumeOffsetPath, s.handleUploadOffset)
+	// 7. 下载模式 (对方从本机拉取文件)
+	mux.HandleFunc("/api/localsend/v2/prepare-download", s.handlePrepareDownload)
+	mux.HandleFunc("/api/localsend/v2/download", s.handleDownload)
+	// 8. 网页共享 (没有 LocalSend 客户端的设备用浏览器下载)
+	mux.HandleFunc("/", s.handleWebShare)
+
+	// 定期清理空闲会话
+	go s.sessions.StartJanitor(SessionJanitorInterval)
 
 	addr := fmt.Sprintf("0.0.0.0:%d", s.port)
 	fmt.Printf("[服务端] HTTP 服务器正在监听 %s\n", addr)
@@ -67,35 +184,26 @@ func (s *FileServer) Start() {
 	//	fmt.Printf("[服务端] 错误: %v\n", err)
 	//}
 
+	// 读超时让断开的连接 (如 Wi-Fi 断开后收不到 RST) 能被及时发现；
+	// 上传文件时间可能很长，上传接口在每次读取前按 UploadStallTimeout 延长读超时 (见 uploadBodyReader)
+	server := &http.Server{
+		Addr:              fmt.Sprintf(":%d", s.port),
+		Handler:           mux,
+		ReadHeaderTimeout: ServerReadHeaderTimeout,
+		ReadTimeout:       ServerReadTimeout,
+		IdleTimeout:       ServerIdleTimeout,
+	}
 	if IsHttps {
-		httpsPort := s.port
-		func() {
-			log.Printf("[HTTPS] listen :%d\n", httpsPort)
-			http.ListenAndServeTLS(
-				fmt.Sprintf(":%d", httpsPort),
-				"server.pem",
-				"server.key",
-				mux,
-			)
-		}()
-	} else {
-		httpPort := s.port
-		func() {
-			log.Printf("[HTTP] listen :%d\n", httpPort)
-			http.ListenAndServe(fmt.Sprintf(":%d", httpPort), mux)
-		}()
+		log.Printf("[HTTPS] listen :%d\n", s.port)
+		return server.ListenAndServeTLS(s.certFile, s.keyFile)
 	}
+	log.Printf("[HTTP] listen :%d\n", s.port)
+	return server.ListenAndServe()
 }
 
-// handleInfo GET /api/localsend/v2/info
-// 返回本机基本信息，用于其他设备通过 IP 直接访问时的探测
-func (s *FileServer) handleInfo(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "方法不允许", http.StatusMethodNotAllowed)
-		return
-	}
-
-	dto := model.InfoDto{
+// infoDto 返回本机信息，download 表示当前是否开启了下载模式
+func (s *FileServer) infoDto() model.InfoDto {
+	return model.InfoDto{
 		Alias:       s.alias,
 		Version:     ProtocolVersion,
 		DeviceModel: s.deviceModel,
@@ -103,11 +211,20 @@ func (s *FileServer) handleInfo(w http.ResponseWriter, r *http.Request) {
 		Fingerprint: s.fingerprint,
 		Port:        s.port,
 		Protocol:    ProtocolTypeHttpStatus,
-		Download:    false,
+		Download:    s.share.Load() != nil,
+	}
+}
+
+// handleInfo GET /api/localsend/v2/info
+// 返回本机基本信息，用于其他设备通过 IP 直接访问时的探测
+func (s *FileServer) handleInfo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "方法不允许", http.StatusMethodNotAllowed)
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(dto)
+	json.NewEncoder(w).Encode(s.infoDto())
 }
 
 // handleRegister POST /api/localsend/v2/register
@@ -115,18 +232,24 @@ func (s *FileServer) handleInfo(w http.ResponseWriter, r *http.Request) {
 func (s *FileServer) handleRegister(w http.ResponseWriter, r *http.Request) {
 	// 实际业务中，这里可以将对方设备加入到"最近设备"列表或缓存中
 	//fmt.Printf("[Server] 心跳ping: %s (%s)\n", req.Alias, req.Fingerprint)
-	dto := model.InfoDto{
-		Alias:       s.alias,
-		Version:     ProtocolVersion,
-		DeviceModel: s.deviceModel,
-		DeviceType:  model.DeviceTypeDesktop,
-		Fingerprint: s.fingerprint,
-		Port:        s.port,
-		Protocol:    ProtocolTypeHttpStatus,
-		Download:    false,
-	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(dto)
+	json.NewEncoder(w).Encode(s.infoDto())
+}
+
+// checkPin 校验请求携带的 PIN (v2.1)：缺失或错误返回 401，多次错误后锁定该地址 (429)
+// 校验失败时已写入响应，返回 false
+func (s *FileServer) checkPin(w http.ResponseWriter, r *http.Request) bool {
+	ip := remoteIP(r.RemoteAddr).String()
+	switch err := s.pins.Check(ip, r.URL.Query().Get("pin")); {
+	case errors.Is(err, ErrPinLocked):
+		fmt.Printf("[服务端] %s PIN 错误次数过多，已暂时锁定\n", ip)
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		return false
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return false
+	}
+	return true
 }
 
 // handlePrepareUpload POST /api/localsend/v2/prepare-upload
@@ -138,40 +261,87 @@ func (s *FileServer) handlePrepareUpload(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	if !s.checkPin(w, r) {
+		return
+	}
+
 	var req model.PrepareUploadRequestDto
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
+	// 文本消息：内容已在 preview 中，经确认器同意后交给消息处理器并返回 204，不创建会话也不需要上传
+	if text, ok := textMessage(req); ok {
+		s.receiveText(w, r, req, text)
+		return
+	}
+
+	// 没有文件的请求不创建会话，否则会白白占用会话名额直到超时
+	if len(req.Files) == 0 {
+		fmt.Printf("[服务端] 拒绝来自 %s 的传输请求: 没有文件\n", req.Info.Alias)
+		http.Error(w, "没有要传输的文件", http.StatusBadRequest)
+		return
+	}
+
+	// 提前拒绝带有危险路径的请求，避免用户确认之后才在上传时失败
+	for _, f := range req.Files {
+		if _, err := sanitizeRelPath(f.FileName); err != nil {
+			fmt.Printf("[服务端] 拒绝来自 %s 的传输请求: %v\n", req.Info.Alias, err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// 超过单文件或单次会话大小上限的请求直接拒绝
+	if err := s.checkLimits(req.Files); err != nil {
+		fmt.Printf("[服务端] 拒绝来自 %s 的传输请求: %v\n", req.Info.Alias, err)
+		status := http.StatusRequestEntityTooLarge
+		if errors.Is(err, ErrInvalidSize) {
+			status = http.StatusBadRequest
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
 	fmt.Printf("[服务端] 收到来自 %s 的文件传输请求: %d 个文件\n", req.Info.Alias, len(req.Files))
 	for _, f := range req.Files {
 		fmt.Printf("  - %s (%d 字节)\n", f.FileName, f.Size)
 	}
 
-	// --- 关键点：这里通常需要用户交互确认 ---
-	// 为了演示，默认自动接受所有请求
-	// 实际项目中这里应该阻塞，直到用户点击"接受"或"拒绝"
-	// ------------------------------------
-
-	// 生成会话 ID
-	sessionId := uuid.New().String()
-	session := &Session{
-		Id:     sessionId,
-		Files:  req.Files,
-		Tokens: make(map[string]string),
+	// 创建会话，等待接收方决定
+	// 会话数已达上限时按协议返回 409，告知发送方接收端正忙
+	sessionId, err := s.sessions.Create(req.Info, req.Files, r.Header.Get(ResumeHeader) == "1")
+	if err != nil {
+		fmt.Printf("[服务端] 正忙，拒绝来自 %s 的传输请求\n", req.Info.Alias)
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
 	}
 
-	// 为每个文件生成传输 Token，用于后续 upload 接口鉴权
-	filesResp := make(map[string]string)
-	for fileId := range req.Files {
-		token := uuid.New().String()
-		session.Tokens[fileId] = token
-		filesResp[fileId] = token
+	// 阻塞直到确认器做出决定；超时或发送方断开连接都视为拒绝
+	ctx, cancel := context.WithTimeout(r.Context(), s.acceptTimeout)
+	decision, err := s.acceptor.Decide(ctx, TransferRequest{
+		SessionId:  sessionId,
+		Sender:     req.Info,
+		RemoteAddr: r.RemoteAddr,
+		Files:      req.Files,
+	})
+	cancel()
+	if err != nil || !decision.Accept || (decision.FileIds != nil && len(decision.FileIds) == 0) {
+		s.sessions.Reject(sessionId)
+		fmt.Printf("[服务端] 已拒绝来自 %s 的传输请求\n", req.Info.Alias)
+		http.Error(w, "传输请求被拒绝", http.StatusForbidden)
+		return
 	}
 
-	// 保存会话状态
-	s.sessions[sessionId] = session
+	// 为被接受的文件生成传输 Token，用于后续 upload 接口鉴权
+	filesResp, err := s.sessions.Accept(sessionId, decision.FileIds, s.sessionDir(decision.Dir))
+	if err != nil {
+		// 等待期间会话可能已被发送方取消
+		s.sessions.Reject(sessionId)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
 
 	// 返回响应，包含 SessionId 和 Tokens
 	resp := model.PrepareUploadResponseDto{
@@ -179,10 +349,66 @@ func (s *FileServer) handlePrepareUpload(w http.ResponseWriter, r *http.Request)
 		Files:     filesResp,
 	}
 
+	// 通过响应头告知发送方本机支持续传，标准客户端会忽略该响应头
+	w.Header().Set(ResumeHeader, "1")
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
 }
 
+// receiveText 处理文本消息
+// 与文件一样先交给确认器决定，拒绝时返回 403；消息处理器限流时返回 429
+func (s *FileServer) receiveText(w http.ResponseWriter, r *http.Request, req model.PrepareUploadRequestDto, text string) {
+	from := req.Info
+	if len(text) > MaxTextMessageSize {
+		fmt.Printf("[服务端] 拒绝来自 %s 的消息: 长度 %s 超过上限\n", from.Alias, FormatSize(int64(len(text))))
+		http.Error(w, "消息过长", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.acceptTimeout)
+	decision, err := s.acceptor.Decide(ctx, TransferRequest{
+		Sender:     from,
+		RemoteAddr: r.RemoteAddr,
+		Files:      req.Files,
+		Text:       text,
+	})
+	cancel()
+	if err != nil || !decision.Accept || (decision.FileIds != nil && len(decision.FileIds) == 0) {
+		fmt.Printf("[服务端] 已拒绝来自 %s 的消息\n", from.Alias)
+		http.Error(w, "消息被拒绝", http.StatusForbidden)
+		return
+	}
+
+	err = s.texts.Deliver(TextMessage{
+		Time:       time.Now(),
+		From:       from,
+		RemoteAddr: r.RemoteAddr,
+		Text:       text,
+	})
+	if errors.Is(err, ErrTextRateLimited) {
+		fmt.Printf("[服务端] 来自 %s 的消息过于频繁，已丢弃\n", from.Alias)
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		return
+	}
+	if err != nil {
+		fmt.Printf("[服务端] 处理来自 %s 的消息失败: %v\n", from.Alias, err)
+		http.Error(w, "处理消息失败", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// sessionDir 计算会话的保存目录，相对路径基于下载目录
+func (s *FileServer) sessionDir(dir string) string {
+	if dir == "" {
+		return s.downloadDir
+	}
+	if filepath.IsAbs(dir) {
+		return filepath.Clean(dir)
+	}
+	return filepath.Join(s.downloadDir, dir)
+}
+
 // handleUpload POST /api/localsend/v2/upload
 // 实际接收文件数据。请求通过 URL 参数携带 sessionId, fileId, token。
 // Body 为文件的原始二进制流。
@@ -202,66 +428,220 @@ func (s *FileServer) handleUpload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 2. 验证会话
-	session, ok := s.sessions[sessionId]
-	if !ok {
-		http.Error(w, "Invalid session", http.StatusForbidden)
-		return
+	// offset 为续传扩展参数，标准 LocalSend 客户端不会携带
+	var offset int64
+	if v := r.URL.Query().Get("offset"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			http.Error(w, "Invalid offset", http.StatusBadRequest)
+			return
+		}
+		offset = n
 	}
 
-	// 3. 验证 Token
-	expectedToken, ok := session.Tokens[fileId]
-	if !ok || expectedToken != token {
+	// 2. 验证会话、文件和 Token，并将文件置为接收中
+	ticket, err := s.sessions.BeginUpload(sessionId, fileId, token, offset)
+	switch {
+	case errors.Is(err, ErrSessionNotFound):
+		http.Error(w, "Invalid session", http.StatusForbidden)
+		return
+	case errors.Is(err, ErrFileNotFound):
+		http.Error(w, "Invalid fileId", http.StatusBadRequest)
+		return
+	case errors.Is(err, ErrInvalidToken):
 		http.Error(w, "Invalid token", http.StatusForbidden)
 		return
+	case errors.Is(err, ErrInvalidOffset):
+		http.Error(w, err.Error(), http.StatusRequestedRangeNotSatisfiable)
+		return
+	case errors.Is(err, ErrUploadBusy):
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
 	}
 
-	// 4. 获取文件元数据
-	fileInfo, ok := session.Files[fileId]
-	if !ok {
-		http.Error(w, "Invalid fileId", http.StatusBadRequest)
+	// 会话取消或被新请求接管时立即中止阻塞中的读取，而不必等到读超时
+	rc := http.NewResponseController(w)
+	stopAbort := context.AfterFunc(ticket.Ctx, func() {
+		rc.SetReadDeadline(time.Now())
+	})
+	defer stopAbort()
+
+	// 无论成功与否，结束时都要记录文件的最终状态；连接中断时改为保留数据等待续传
+	var uploadErr error
+	var res storeResult
+	defer func() {
+		if errors.Is(uploadErr, ErrUploadInterrupted) {
+			s.sessions.SuspendUpload(sessionId, fileId, res.Partial, res.Written)
+			return
+		}
+		ticket.Progress.Done(fileId, uploadErr)
+		s.sessions.FinishUpload(sessionId, fileId, uploadErr)
+	}()
+
+	// 3. 准备保存路径
+	// 默认保存到当前目录下的 downloads 文件夹，策略可将会话路由到其他目录
+	fileInfo := ticket.File
+	downloadDir := ticket.Dir
+
+	// 文件名可能带有相对目录 (发送文件夹时)，在下载目录下还原目录结构
+	// SafeCreatePath 会拒绝 ..、绝对路径、符号链接等，防止路径遍历攻击 (../../etc/passwd)
+	savePath, err := SafeCreatePath(downloadDir, fileInfo.FileName)
+	if errors.Is(err, ErrUnsafePath) {
+		uploadErr = err
+		fmt.Printf("[服务端] 拒绝文件: %v\n", err)
+		http.Error(w, "Invalid file name", http.StatusBadRequest)
 		return
 	}
-
-	// 5. 准备保存路径
-	// 默认保存到当前目录下的 downloads 文件夹
-	downloadDir := DefaultDownloadDir
-	if err := os.MkdirAll(downloadDir, 0755); err != nil {
+	if err != nil {
+		uploadErr = err
 		http.Error(w, "Failed to create download dir", http.StatusInternalServerError)
 		return
 	}
 
-	// 安全处理文件名，防止路径遍历攻击 (../../etc/passwd)
-	safeFileName := filepath.Base(fileInfo.FileName)
-	// 这里可以添加逻辑：如果文件已存在，自动重命名 (例如 file (1).txt)
-	savePath := filepath.Join(downloadDir, safeFileName)
+	// skip 策略下目标已存在时直接跳过，无需接收数据
+	if s.conflict == ConflictSkip {
+		if _, err := os.Lstat(savePath); err == nil {
+			fmt.Printf("[服务端] %s 已存在，按 skip 策略跳过\n", fileInfo.FileName)
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+	}
 
-	outFile, err := os.Create(savePath)
-	if err != nil {
-		http.Error(w, "Failed to create file", http.StatusInternalServerError)
+	// 请求头声明的长度与 prepare-upload 时不一致，无需接收即可判定失败
+	remaining := fileInfo.Size - ticket.Offset
+	if r.ContentLength >= 0 && r.ContentLength != remaining {
+		uploadErr = fmt.Errorf("Content-Length %d 与剩余的文件大小 %d 不符", r.ContentLength, remaining)
+		fmt.Printf("[服务端] 拒绝文件 %s: %v\n", fileInfo.FileName, uploadErr)
+		status := http.StatusBadRequest
+		if r.ContentLength > remaining {
+			status = http.StatusRequestEntityTooLarge
+		}
+		http.Error(w, uploadErr.Error(), status)
 		return
 	}
-	defer outFile.Close()
 
-	fmt.Printf("[服务端] 正在接收文件: %s ...\n", safeFileName)
+	if ticket.Offset > 0 {
+		fmt.Printf("[服务端] 正在续传文件: %s (从 %d 字节处继续) ...\n", fileInfo.FileName, ticket.Offset)
+	} else {
+		fmt.Printf("[服务端] 正在接收文件: %s ...\n", fileInfo.FileName)
+	}
 
-	// 6. 接收并写入数据
-	// io.Copy 会高效地将 Request Body 流复制到 File
-	written, err := io.Copy(outFile, r.Body)
+	// 4. 接收并写入数据
+	// 先写临时文件，校验大小和哈希后再按重名策略放到最终位置，实际保存的文件名可能与请求中的不同
+	quarantineDir := ""
+	if s.quarantine {
+		quarantineDir = filepath.Join(downloadDir, QuarantineDirName)
+	}
+	body := ticket.Progress.Reader(fileId, &uploadBodyReader{ctx: ticket.Ctx, rc: rc, r: r.Body, ticket: ticket}, ticket.Offset)
+	res, err = storeUpload(ticket.Ctx, body, savePath, fileInfo, ticket.Partial, ticket.Offset, s.conflict, quarantineDir)
+	if errors.Is(err, ErrFileSkipped) {
+		fmt.Printf("[服务端] %s 已存在，按 skip 策略跳过\n", fileInfo.FileName)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
 	if err != nil {
-		fmt.Printf("[服务端] 写入文件失败: %v\n", err)
-		http.Error(w, "写入文件失败", http.StatusInternalServerError)
+		uploadErr = err
+		fmt.Printf("[服务端] 接收 %s 失败: %v\n", fileInfo.FileName, err)
+		switch {
+		case errors.Is(err, ErrUploadTooLarge):
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		case errors.Is(err, ErrUploadIncomplete):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, ErrHashMismatch):
+			if res.Path != "" {
+				fmt.Printf("[服务端] 已将 %s 移入隔离区: %s\n", fileInfo.FileName, res.Path)
+			}
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		default:
+			http.Error(w, "写入文件失败", http.StatusInternalServerError)
+		}
 		return
 	}
 
-	if written != fileInfo.Size {
-		fmt.Printf("[服务端] 警告: 实际接收大小 %d 与预期 %d 不符\n", written, fileInfo.Size)
+	storedName := filepath.ToSlash(res.Path)
+	if rel, err := filepath.Rel(downloadDir, res.Path); err == nil {
+		storedName = filepath.ToSlash(rel)
+	}
+	verified := "未提供哈希"
+	if fileInfo.Hash != "" {
+		verified = "哈希校验通过"
+	}
+	if storedName != fileInfo.FileName {
+		fmt.Printf("[服务端] 文件接收成功: %s (重名，保存为 %s, %d 字节, %s)\n", fileInfo.FileName, storedName, res.Written, verified)
+	} else {
+		fmt.Printf("[服务端] 文件接收成功: %s (%d 字节, %s)\n", storedName, res.Written, verified)
 	}
 
-	fmt.Printf("[服务端] 文件接收成功: %s (%d 字节)\n", safeFileName, written)
+	// 记录到接收清单
+	err = appendManifest(filepath.Join(downloadDir, manifestFileName), ManifestEntry{
+		Time:        time.Now(),
+		SessionId:   ticket.SessionId,
+		Sender:      ticket.Sender.Alias,
+		Fingerprint: ticket.Sender.Fingerprint,
+		FileName:    fileInfo.FileName,
+		StoredAs:    storedName,
+		Size:        res.Written,
+		Sha256:      res.Hash,
+		Verified:    fileInfo.Hash != "",
+	})
+	if err != nil {
+		fmt.Printf("[服务端] %v\n", err)
+	}
 	w.WriteHeader(http.StatusOK)
 }
 
+// uploadBodyReader 读取上传数据
+// 每次读取前把连接的读超时推后 UploadStallTimeout：对方停止发送数据超过该时间即判定连接中断，
+// 已接收的数据保留等待续传。ctx 在延长读超时之后检查，
+// 与 handleUpload 中 ctx 结束时把读超时设为当前时间的操作配合，不会错过中止。
+type uploadBodyReader struct {
+	ctx    context.Context
+	rc     *http.ResponseController
+	r      io.Reader
+	ticket UploadTicket
+}
+
+func (u *uploadBodyReader) Read(p []byte) (int, error) {
+	u.rc.SetReadDeadline(time.Now().Add(UploadStallTimeout))
+	if err := u.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := u.r.Read(p)
+	if n > 0 {
+		u.ticket.Touch()
+	}
+	return n, err
+}
+
+// handleUploadOffset GET ResumeOffsetPath (续传扩展)
+// 返回某个文件已保留的字节数，发送方从该位置续传。参数与 upload 接口相同。
+// 文件仍在接收时先接管旧的上传，旧请求未能及时退出时返回 503，发送方稍后重试。
+func (s *FileServer) handleUploadOffset(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "方法不允许", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	offset, err := s.sessions.UploadOffset(q.Get("sessionId"), q.Get("fileId"), q.Get("token"))
+	if errors.Is(err, ErrUploadBusy) {
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(model.UploadOffsetDto{Offset: offset})
+}
+
 // handleCancel POST /api/localsend/v2/cancel
 // 发送方或接收方取消传输
 func (s *FileServer) handleCancel(w http.ResponseWriter, r *http.Request) {
@@ -270,9 +650,112 @@ func (s *FileServer) handleCancel(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	sessionId := r.URL.Query().Get("sessionId")
-	if sessionId != "" {
-		delete(s.sessions, sessionId)
+	if sessionId != "" && s.sessions.Cancel(sessionId) {
 		fmt.Printf("[服务端] 会话 %s 已取消\n", sessionId)
 	}
 	w.WriteHeader(http.StatusOK)
 }
+
+// handlePrepareDownload POST /api/localsend/v2/prepare-download
+// 下载模式：对方请求本机发布的文件列表。携带仍然有效的 sessionId 时沿用该会话，
+// 否则交给确认器决定是否允许对方下载，以及允许下载哪些文件。
+func (s *FileServer) handlePrepareDownload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "方法不允许", http.StatusMethodNotAllowed)
+		return
+	}
+	share := s.share.Load()
+	if share == nil {
+		http.Error(w, ErrShareInactive.Error(), http.StatusForbidden)
+		return
+	}
+	if !s.checkPin(w, r) {
+		return
+	}
+
+	// prepare-download 请求不携带对方的设备信息
+	visitor := model.RegisterDto{Alias: "LocalSend 客户端"}
+	sessionId, err := s.authorizeDownload(r, share, r.URL.Query().Get("sessionId"), visitor)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	files := make(map[string]model.FileDto)
+	for _, f := range share.SessionFiles(sessionId) {
+		files[f.Id] = f
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(model.PrepareDownloadResponseDto{
+		Info:      s.infoDto(),
+		SessionId: sessionId,
+		Files:     files,
+	})
+}
+
+// authorizeDownload 为访问者取得下载会话
+// sessionId 是该地址仍然有效的会话时直接沿用，否则阻塞等待确认器决定，被拒绝时返回 ErrDownloadRejected
+func (s *FileServer) authorizeDownload(r *http.Request, share *Share, sessionId string, visitor model.RegisterDto) (string, error) {
+	ip := remoteIP(r.RemoteAddr).String()
+	if sessionId != "" && share.Resume(sessionId, ip) {
+		return sessionId, nil
+	}
+
+	fmt.Printf("[服务端] %s (%s) 请求下载共享文件\n", visitor.Alias, ip)
+	ctx, cancel := context.WithTimeout(r.Context(), s.acceptTimeout)
+	decision, err := s.acceptor.Decide(ctx, share.request(visitor, r.RemoteAddr))
+	cancel()
+	if err != nil || !decision.Accept || (decision.FileIds != nil && len(decision.FileIds) == 0) {
+		fmt.Printf("[服务端] 已拒绝 %s 的下载请求\n", ip)
+		return "", ErrDownloadRejected
+	}
+	return share.Open(ip, decision.FileIds), nil
+}
+
+// handleDownload GET /api/localsend/v2/download?sessionId=&fileId=
+// 下载模式：返回文件的原始内容，支持 Range 请求
+func (s *FileServer) handleDownload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "方法不允许", http.StatusMethodNotAllowed)
+		return
+	}
+	share := s.share.Load()
+	if share == nil {
+		http.Error(w, ErrShareInactive.Error(), http.StatusForbidden)
+		return
+	}
+
+	ip := remoteIP(r.RemoteAddr).String()
+	q := r.URL.Query()
+	f, err := share.Lookup(q.Get("sessionId"), q.Get("fileId"), ip)
+	switch {
+	case errors.Is(err, ErrFileNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	file, err := os.Open(f.path)
+	if err != nil {
+		fmt.Printf("[服务端] 打开共享文件 %s 失败: %v\n", f.path, err)
+		http.Error(w, "读取文件失败", http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+
+	// 发布后文件被修改时，内容与文件列表中的大小和哈希不再一致
+	fi, err := file.Stat()
+	if err != nil || fi.Size() != f.dto.Size {
+		fmt.Printf("[服务端] 共享文件 %s 在发布后被修改，拒绝下载\n", f.path)
+		http.Error(w, "文件在发布后被修改", http.StatusConflict)
+		return
+	}
+
+	fmt.Printf("[服务端] %s 正在下载 %s\n", ip, f.dto.FileName)
+	w.Header().Set("Content-Type", f.dto.FileType)
+	// 浏览器通过网页下载时按原文件名保存，而不是直接打开
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": path.Base(f.dto.FileName)}))
+	http.ServeContent(w, r, path.Base(f.dto.FileName), fi.ModTime(), file)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"chrelyonly-localsend-go/model"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// postPrepareUpload 向 s 的 prepare-upload 发送 files，返回状态码
+func postPrepareUpload(t *testing.T, s *FileServer, files map[string]model.FileDto) int {
+	t.Helper()
+	body, err := json.Marshal(model.PrepareUploadRequestDto{
+		Info:  model.RegisterDto{Alias: "peer", Fingerprint: "abc"},
+		Files: files,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/api/localsend/v2/prepare-upload", bytes.NewReader(body))
+	w := httptest.NewRecorder()
+	s.handlePrepareUpload(w, req)
+	return w.Code
+}
+
+func TestPrepareUploadEmpty(t *testing.T) {
+	s := NewFileServer(0, "test", "fp", "test", "", "", 1)
+	for _, files := range []map[string]model.FileDto{nil, {}} {
+		if code := postPrepareUpload(t, s, files); code != http.StatusBadRequest {
+			t.Errorf("没有文件时状态码为 %d，应为 400", code)
+		}
+	}
+	if n := len(s.sessions.sessions); n != 0 {
+		t.Errorf("没有文件的请求创建了 %d 个会话", n)
+	}
+}
+
+func TestCheckLimitsOverflow(t *testing.T) {
+	half := int64(math.MaxInt64/2 + 1)
+	files := map[string]model.FileDto{
+		"a": {Id: "a", FileName: "a.bin", Size: half},
+		"b": {Id: "b", FileName: "b.bin", Size: half},
+	}
+	for _, limit := range []int64{0, 1 << 30} {
+		s := NewFileServer(0, "test", "fp", "test", "", "", 1)
+		s.SetLimits(0, limit)
+		if err := s.checkLimits(files); err == nil {
+			t.Errorf("会话上限 %d: 总大小溢出时 checkLimits 应当失败", limit)
+		}
+		if code := postPrepareUpload(t, s, files); code != http.StatusRequestEntityTooLarge {
+			t.Errorf("会话上限 %d: 总大小溢出时状态码为 %d，应为 413", limit, code)
+		}
+	}
+}
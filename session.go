@@ -0,0 +1,524 @@
+package main
+
+import (
+	"chrelyonly-localsend-go/model"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// TransferState 会话和文件的传输状态
+type TransferState string
+
+const (
+	StateWaiting   TransferState = "waiting"   // 等待接收方决定是否接受
+	StateAccepted  TransferState = "accepted"  // 已接受，等待上传
+	StateReceiving TransferState = "receiving" // 正在接收数据
+	StateFinished  TransferState = "finished"  // 已完成
+	StateCancelled TransferState = "cancelled" // 被取消或未被接受
+	StateFailed    TransferState = "failed"    // 传输失败
+)
+
+// Terminal 是否为终止状态，终止状态不会再发生变化
+func (st TransferState) Terminal() bool {
+	return st == StateFinished || st == StateCancelled || st == StateFailed
+}
+
+// transitions 定义合法的状态流转
+var transitions = map[TransferState][]TransferState{
+	StateWaiting:   {StateAccepted, StateCancelled, StateFailed},
+	StateAccepted:  {StateReceiving, StateCancelled, StateFailed},
+	StateReceiving: {StateFinished, StateCancelled, StateFailed, StateAccepted}, // 回到 accepted 表示上传中断，可续传
+}
+
+// canTransition 判断 from -> to 是否合法
+func canTransition(from, to TransferState) bool {
+	for _, st := range transitions[from] {
+		if st == to {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	ErrSessionNotFound = errors.New("会话不存在")
+	ErrFileNotFound    = errors.New("文件不存在")
+	ErrInvalidToken    = errors.New("Token 无效")
+	ErrInvalidState    = errors.New("状态不允许该操作")
+	ErrSessionBusy     = errors.New("已有传输会话进行中")
+	ErrInvalidOffset   = errors.New("续传位置与已接收的数据不符")
+	ErrUploadBusy      = errors.New("上一次上传尚未结束")
+	// ErrUploadSuperseded 同一文件的新请求接管了上传，旧请求应保留数据退出
+	ErrUploadSuperseded = errors.New("上传已被新的请求接管")
+)
+
+// FileTransfer 会话中单个文件的传输状态
+type FileTransfer struct {
+	File      model.FileDto
+	Token     string // 上传鉴权 Token，未被接受的文件为空
+	State     TransferState
+	UpdatedAt time.Time
+
+	abort    context.CancelCauseFunc // 接收中时有效，会话取消或被接管时中止正在进行的上传
+	stopped  chan struct{}           // 接收中时有效，上传请求结束时关闭
+	lastRead atomic.Int64            // 最后一次读到上传数据的时间 (UnixNano)
+
+	// 上传中断后保留的临时文件及其已接收的字节数，用于续传
+	partial  string
+	received int64
+}
+
+// release 结束文件的上传上下文，正在进行的上传会因此中止，cause 为中止原因
+func (ft *FileTransfer) release(cause error) {
+	if ft.abort != nil {
+		ft.abort(cause)
+		ft.abort = nil
+	}
+}
+
+// markStopped 上传请求已结束，唤醒等待接管的请求
+func (ft *FileTransfer) markStopped() {
+	if ft.stopped != nil {
+		close(ft.stopped)
+		ft.stopped = nil
+	}
+}
+
+// discardPartial 删除为续传保留的临时文件
+func (ft *FileTransfer) discardPartial() {
+	if ft.partial != "" {
+		os.Remove(ft.partial)
+		ft.partial = ""
+		ft.received = 0
+	}
+}
+
+// Session 代表一次传输会话
+type Session struct {
+	Id        string
+	Sender    model.RegisterDto        // 发送方设备信息
+	Files     map[string]*FileTransfer // key: fileId
+	Dir       string                   // 保存目录，接受时确定
+	Resumable bool                     // 发送方会在连接中断后续传 (声明了续传扩展，或已用同一 Token 重新连接)
+	State     TransferState
+	CreatedAt time.Time
+	UpdatedAt time.Time
+
+	Progress *Progress // 接收进度，未设置 listener 时为 nil
+}
+
+// setState 修改会话状态并刷新时间戳
+func (sess *Session) setState(st TransferState, now time.Time) {
+	sess.State = st
+	sess.UpdatedAt = now
+}
+
+// refreshState 还有文件未上传时调用：没有正在接收的文件则回到 accepted，以便空闲超时能生效
+func (sess *Session) refreshState(now time.Time) {
+	for _, ft := range sess.Files {
+		if ft.State == StateReceiving {
+			return
+		}
+	}
+	sess.setState(StateAccepted, now)
+}
+
+// lastActivity 会话最后一次活动的时间，正在接收的文件以最后一次读到数据的时间为准
+func (sess *Session) lastActivity() time.Time {
+	last := sess.UpdatedAt
+	for _, ft := range sess.Files {
+		if ft.State != StateReceiving {
+			continue
+		}
+		if t := time.Unix(0, ft.lastRead.Load()); t.After(last) {
+			last = t
+		}
+	}
+	return last
+}
+
+// settle 在所有文件都进入终止状态后结束会话
+// 只要有一个文件成功即视为完成，否则视为失败
+func (sess *Session) settle(now time.Time) bool {
+	finished := false
+	for _, ft := range sess.Files {
+		if !ft.State.Terminal() {
+			return false
+		}
+		if ft.State == StateFinished {
+			finished = true
+		}
+	}
+	if finished {
+		sess.setState(StateFinished, now)
+	} else {
+		sess.setState(StateFailed, now)
+	}
+	return true
+}
+
+// SessionManager 并发安全的会话管理器
+// HTTP 处理函数运行在各自的 goroutine 中，所有会话读写都必须经过这里。
+// 会话在所有文件结束后，或超过 idleTimeout 无活动时自动清理。
+type SessionManager struct {
+	mu          sync.Mutex
+	sessions    map[string]*Session
+	idleTimeout time.Duration
+	maxSessions int              // 同时存在的会话上限，0 表示不限制
+	progress    ProgressListener // 接收进度的 listener，可为 nil
+}
+
+// NewSessionManager 创建会话管理器
+// maxSessions 为 1 时即 LocalSend 的单会话模式：已有会话（包括等待确认的）时拒绝新请求
+func NewSessionManager(idleTimeout time.Duration, maxSessions int) *SessionManager {
+	return &SessionManager{
+		sessions:    make(map[string]*Session),
+		idleTimeout: idleTimeout,
+		maxSessions: maxSessions,
+	}
+}
+
+// SetProgressListener 设置接收进度的 listener，对之后接受的会话生效
+func (m *SessionManager) SetProgressListener(l ProgressListener) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.progress = l
+}
+
+// Create 为一次 prepare-upload 请求创建会话，初始状态为 waiting
+// 会话数达到上限时返回 ErrSessionBusy
+func (m *SessionManager) Create(info model.RegisterDto, files map[string]model.FileDto, resumable bool) (string, error) {
+	now := time.Now()
+	sess := &Session{
+		Id:        uuid.New().String(),
+		Sender:    info,
+		Resumable: resumable,
+		Files:     make(map[string]*FileTransfer, len(files)),
+		State:     StateWaiting,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	for fileId, f := range files {
+		sess.Files[fileId] = &FileTransfer{File: f, State: StateWaiting, UpdatedAt: now}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.maxSessions > 0 && len(m.sessions) >= m.maxSessions {
+		return "", ErrSessionBusy
+	}
+	m.sessions[sess.Id] = sess
+	return sess.Id, nil
+}
+
+// Accept 接受会话中的文件，为每个被接受的文件生成上传 Token
+// fileIds 为 nil 时接受全部文件，否则只接受其中列出的文件，其余置为 cancelled。
+// dir 为本次会话文件的保存目录。
+// 返回 fileId -> token，用于 prepare-upload 响应
+func (m *SessionManager) Accept(sessionId string, fileIds []string, dir string) (map[string]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sess, ok := m.sessions[sessionId]
+	if !ok {
+		return nil, ErrSessionNotFound
+	}
+	if !canTransition(sess.State, StateAccepted) {
+		return nil, fmt.Errorf("%w: 会话状态为 %s", ErrInvalidState, sess.State)
+	}
+
+	accepted := make(map[string]bool, len(sess.Files))
+	for fileId := range sess.Files {
+		accepted[fileId] = fileIds == nil
+	}
+	for _, fileId := range fileIds {
+		if _, ok := sess.Files[fileId]; !ok {
+			return nil, fmt.Errorf("%w: %s", ErrFileNotFound, fileId)
+		}
+		accepted[fileId] = true
+	}
+
+	now := time.Now()
+	tokens := make(map[string]string, len(sess.Files))
+	var acceptedFiles []model.FileDto
+	for fileId, ft := range sess.Files {
+		ft.UpdatedAt = now
+		if !accepted[fileId] {
+			ft.State = StateCancelled
+			continue
+		}
+		ft.Token = uuid.New().String()
+		ft.State = StateAccepted
+		tokens[fileId] = ft.Token
+		acceptedFiles = append(acceptedFiles, ft.File)
+	}
+	sess.Dir = dir
+	sess.Progress = NewProgress(m.progress, ProgressReceive, sess.Id, sess.Sender.Alias, acceptedFiles)
+	sess.setState(StateAccepted, now)
+	return tokens, nil
+}
+
+// Reject 拒绝会话，会话被移除
+func (m *SessionManager) Reject(sessionId string) {
+	m.Cancel(sessionId)
+}
+
+// UploadTicket BeginUpload 校验通过后返回的上传信息
+type UploadTicket struct {
+	SessionId string
+	Sender    model.RegisterDto // 发送方设备信息
+	File      model.FileDto     // 文件元数据
+	Dir       string            // 保存目录
+	Ctx       context.Context   // 会话被取消时结束，接收方应中止写入并丢弃数据
+	Partial   string            // 续传时已有的临时文件，为空表示从头接收
+	Offset    int64             // 续传起始位置
+	Progress  *Progress         // 会话的接收进度，可为 nil
+
+	file *FileTransfer
+}
+
+// Touch 记录读到了上传数据，会话空闲清理以此判断上传是否仍在进行
+func (t UploadTicket) Touch() {
+	t.file.lastRead.Store(time.Now().UnixNano())
+}
+
+// lookup 校验会话、文件和 Token，调用方需持有锁
+func (m *SessionManager) lookup(sessionId, fileId, token string) (*Session, *FileTransfer, error) {
+	sess, ok := m.sessions[sessionId]
+	if !ok {
+		return nil, nil, ErrSessionNotFound
+	}
+	ft, ok := sess.Files[fileId]
+	if !ok {
+		return nil, nil, ErrFileNotFound
+	}
+	if ft.Token == "" || ft.Token != token {
+		return nil, nil, ErrInvalidToken
+	}
+	return sess, ft, nil
+}
+
+// takeOverLocked 校验会话、文件和 Token；文件仍处于 receiving 时接管上传，调用方需持有锁
+// 持有 Token 的发送方重新上传或查询续传位置，说明它已放弃之前的连接，
+// 而服务端可能还没发现旧连接断开 (如 Wi-Fi 断开后没有 RST)。此时中止旧的上传，
+// 等它保留已接收的数据并退出后再继续；等待期间会暂时释放锁，超过 UploadTakeoverTimeout 返回 ErrUploadBusy。
+func (m *SessionManager) takeOverLocked(sessionId, fileId, token string) (*Session, *FileTransfer, error) {
+	deadline := time.Now().Add(UploadTakeoverTimeout)
+	for {
+		sess, ft, err := m.lookup(sessionId, fileId, token)
+		if err != nil || ft.State != StateReceiving {
+			return sess, ft, err
+		}
+		if !time.Now().Before(deadline) {
+			return nil, nil, ErrUploadBusy
+		}
+
+		// 发送方带着 Token 回来了，旧上传保留的数据对它有用
+		sess.Resumable = true
+		stopped := ft.stopped
+		ft.release(ErrUploadSuperseded)
+		m.mu.Unlock()
+		timer := time.NewTimer(time.Until(deadline))
+		select {
+		case <-stopped:
+		case <-timer.C:
+		}
+		timer.Stop()
+		m.mu.Lock()
+	}
+}
+
+// BeginUpload 校验上传参数，并将文件置为 receiving
+// 文件正在接收时接管之前的上传 (见 takeOverLocked)；offset 大于 0 表示续传，必须与已保留的数据长度一致，
+// offset 为 0 时丢弃之前保留的数据，从头接收。
+func (m *SessionManager) BeginUpload(sessionId, fileId, token string, offset int64) (UploadTicket, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sess, ft, err := m.takeOverLocked(sessionId, fileId, token)
+	if err != nil {
+		return UploadTicket{}, err
+	}
+	if !canTransition(ft.State, StateReceiving) {
+		return UploadTicket{}, fmt.Errorf("%w: 文件状态为 %s", ErrInvalidState, ft.State)
+	}
+	if offset == 0 {
+		ft.discardPartial()
+	} else if ft.partial == "" || offset != ft.received {
+		return UploadTicket{}, fmt.Errorf("%w: 请求 %d，已接收 %d", ErrInvalidOffset, offset, ft.received)
+	}
+
+	ctx, abort := context.WithCancelCause(context.Background())
+	now := time.Now()
+	ft.State = StateReceiving
+	ft.UpdatedAt = now
+	ft.abort = abort
+	ft.stopped = make(chan struct{})
+	ft.lastRead.Store(now.UnixNano())
+	sess.setState(StateReceiving, now)
+	return UploadTicket{
+		SessionId: sess.Id,
+		Sender:    sess.Sender,
+		File:      ft.File,
+		Dir:       sess.Dir,
+		Ctx:       ctx,
+		Partial:   ft.partial,
+		Offset:    ft.received,
+		Progress:  sess.Progress,
+		file:      ft,
+	}, nil
+}
+
+// UploadOffset 返回文件已保留的字节数，发送方据此续传
+// 文件仍在接收时先接管之前的上传 (见 takeOverLocked)，保证返回的是旧请求退出后实际保留的长度
+func (m *SessionManager) UploadOffset(sessionId, fileId, token string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, ft, err := m.takeOverLocked(sessionId, fileId, token)
+	if err != nil {
+		return 0, err
+	}
+	return ft.received, nil
+}
+
+// SuspendUpload 上传因连接中断而停止时调用，保留临时文件并把文件置回 accepted，等待续传
+// 不支持续传的发送方 (如标准 LocalSend 客户端) 断开后不会再回来，此时直接取消会话并删除临时文件，
+// 不再占用会话名额直到空闲超时。
+func (m *SessionManager) SuspendUpload(sessionId, fileId, partial string, received int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var ft *FileTransfer
+	sess, ok := m.sessions[sessionId]
+	if ok {
+		ft, ok = sess.Files[fileId]
+	}
+	if !ok || !canTransition(ft.State, StateAccepted) {
+		// 会话已被取消，临时文件不再需要
+		os.Remove(partial)
+		return
+	}
+
+	now := time.Now()
+	if !sess.Resumable {
+		os.Remove(partial)
+		m.cancelLocked(sess, now)
+		fmt.Printf("[服务端] 会话 %s 的发送方已断开且不支持续传，已取消\n", sessionId)
+		return
+	}
+	ft.State = StateAccepted
+	ft.UpdatedAt = now
+	ft.release(nil)
+	ft.markStopped()
+	ft.partial = partial
+	ft.received = received
+	sess.UpdatedAt = now
+	sess.refreshState(now)
+}
+
+// FinishUpload 记录文件的上传结果，err 为 nil 表示成功
+// 会话中所有文件结束后，会话随之结束并被移除
+func (m *SessionManager) FinishUpload(sessionId, fileId string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sess, ok := m.sessions[sessionId]
+	if !ok {
+		return
+	}
+	ft, ok := sess.Files[fileId]
+	if !ok {
+		return
+	}
+
+	next := StateFinished
+	if err != nil {
+		next = StateFailed
+	}
+	if !canTransition(ft.State, next) {
+		return
+	}
+	now := time.Now()
+	ft.State = next
+	ft.UpdatedAt = now
+	ft.release(nil)
+	ft.markStopped()
+	ft.discardPartial()
+	sess.UpdatedAt = now
+
+	if sess.settle(now) {
+		delete(m.sessions, sessionId)
+		fmt.Printf("[服务端] 会话 %s 已结束 (%s)\n", sessionId, sess.State)
+		return
+	}
+	sess.refreshState(now)
+}
+
+// Cancel 取消会话，未结束的文件全部置为 cancelled
+func (m *SessionManager) Cancel(sessionId string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sess, ok := m.sessions[sessionId]
+	if !ok {
+		return false
+	}
+	m.cancelLocked(sess, time.Now())
+	return true
+}
+
+// cancelLocked 取消并移除会话，调用方需持有锁
+func (m *SessionManager) cancelLocked(sess *Session, now time.Time) {
+	for _, ft := range sess.Files {
+		if !ft.State.Terminal() {
+			ft.State = StateCancelled
+			ft.UpdatedAt = now
+			ft.release(nil)
+			ft.markStopped()
+		}
+		ft.discardPartial()
+	}
+	sess.setState(StateCancelled, now)
+	delete(m.sessions, sess.Id)
+}
+
+// Expire 取消超过 idleTimeout 无活动的会话
+// 正在接收的会话只要仍在读到数据就不会被清理；连接挂起不再有数据的会话同样会被取消，
+// 避免在单会话模式下一直占用唯一的会话名额。
+func (m *SessionManager) Expire() {
+	now := time.Now()
+	deadline := now.Add(-m.idleTimeout)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, sess := range m.sessions {
+		// 等待确认的会话由 handlePrepareUpload 在 acceptTimeout 内接受或拒绝，
+		// 不按空闲超时清理，否则接受时间超过 idleTimeout 时会找不到会话
+		if sess.State == StateWaiting {
+			continue
+		}
+		if sess.lastActivity().Before(deadline) {
+			m.cancelLocked(sess, now)
+			fmt.Printf("[服务端] 会话 %s 空闲超时，已清理\n", id)
+		}
+	}
+}
+
+// StartJanitor 定期清理空闲会话
+// 这是一个阻塞方法，建议在 goroutine 中运行
+func (m *SessionManager) StartJanitor(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		m.Expire()
+	}
+}
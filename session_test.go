@@ -0,0 +1,218 @@
+package main
+
+import (
+	"chrelyonly-localsend-go/model"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestSession 创建一个只有一个文件且已被接受的会话，返回会话 ID 和 Token
+func newTestSession(t *testing.T, m *SessionManager) (string, string) {
+	t.Helper()
+	files := map[string]model.FileDto{"f1": {Id: "f1", FileName: "a.bin", Size: 100}}
+	sessionId, err := m.Create(model.RegisterDto{Alias: "test"}, files, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := m.Accept(sessionId, nil, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sessionId, tokens["f1"]
+}
+
+// fakeUpload 模拟一个卡在读取上的上传请求：直到 ctx 结束才退出，
+// 被接管时像 handleUpload 一样保留 received 字节，否则按失败结束
+func fakeUpload(m *SessionManager, ticket UploadTicket, partial string, received int64) {
+	<-ticket.Ctx.Done()
+	if errors.Is(context.Cause(ticket.Ctx), ErrUploadSuperseded) {
+		m.SuspendUpload(ticket.SessionId, ticket.File.Id, partial, received)
+		return
+	}
+	m.FinishUpload(ticket.SessionId, ticket.File.Id, context.Cause(ticket.Ctx))
+}
+
+func TestUploadTakeover(t *testing.T) {
+	m := NewSessionManager(time.Minute, 1)
+	sessionId, token := newTestSession(t, m)
+
+	ticket, err := m.BeginUpload(sessionId, "f1", token, 0)
+	if err != nil {
+		t.Fatalf("BeginUpload: %v", err)
+	}
+	partial := filepath.Join(t.TempDir(), "a.bin.part")
+	if err := os.WriteFile(partial, make([]byte, 40), 0600); err != nil {
+		t.Fatal(err)
+	}
+	go fakeUpload(m, ticket, partial, 40)
+
+	// 旧连接已断开但服务端未察觉，文件仍为 receiving：查询续传位置会接管上传并返回实际保留的长度
+	offset, err := m.UploadOffset(sessionId, "f1", token)
+	if err != nil || offset != 40 {
+		t.Fatalf("UploadOffset = %d, %v; 应为 40", offset, err)
+	}
+	if !errors.Is(context.Cause(ticket.Ctx), ErrUploadSuperseded) {
+		t.Errorf("旧上传的中止原因为 %v，应为 ErrUploadSuperseded", context.Cause(ticket.Ctx))
+	}
+
+	ticket2, err := m.BeginUpload(sessionId, "f1", token, offset)
+	if err != nil {
+		t.Fatalf("续传 BeginUpload: %v", err)
+	}
+	if ticket2.Partial != partial || ticket2.Offset != 40 {
+		t.Errorf("续传信息为 %q@%d，应为 %q@40", ticket2.Partial, ticket2.Offset, partial)
+	}
+
+	// 不先查询位置、直接重新上传同样会接管
+	go fakeUpload(m, ticket2, partial, 70)
+	ticket3, err := m.BeginUpload(sessionId, "f1", token, 70)
+	if err != nil {
+		t.Fatalf("直接重新上传 BeginUpload: %v", err)
+	}
+	m.FinishUpload(sessionId, "f1", nil)
+	if ticket3.Offset != 70 {
+		t.Errorf("续传位置为 %d，应为 70", ticket3.Offset)
+	}
+	if _, err := os.Stat(partial); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("完成后临时文件应被删除: %v", err)
+	}
+}
+
+func TestUploadTakeoverWrongToken(t *testing.T) {
+	m := NewSessionManager(time.Minute, 1)
+	sessionId, token := newTestSession(t, m)
+	ticket, err := m.BeginUpload(sessionId, "f1", token, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.UploadOffset(sessionId, "f1", "wrong"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Token 错误时 UploadOffset 返回 %v，应为 ErrInvalidToken", err)
+	}
+	if ticket.Ctx.Err() != nil {
+		t.Error("Token 错误的请求不应中止正在进行的上传")
+	}
+}
+
+func TestExpireStalledUpload(t *testing.T) {
+	const idle = 50 * time.Millisecond
+	m := NewSessionManager(idle, 0)
+
+	stalledId, stalledToken := newTestSession(t, m)
+	stalled, err := m.BeginUpload(stalledId, "f1", stalledToken, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	activeId, activeToken := newTestSession(t, m)
+	active, err := m.BeginUpload(activeId, "f1", activeToken, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 只有 active 持续读到数据
+	for i := 0; i < 4; i++ {
+		time.Sleep(idle / 2)
+		active.Touch()
+	}
+	m.Expire()
+
+	if stalled.Ctx.Err() == nil {
+		t.Error("长时间没有数据的接收会话应被取消")
+	}
+	if _, err := m.UploadOffset(stalledId, "f1", stalledToken); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("被清理的会话仍然存在: %v", err)
+	}
+	if active.Ctx.Err() != nil {
+		t.Error("仍在读到数据的接收会话不应被取消")
+	}
+}
+
+func TestExpireKeepsWaitingSession(t *testing.T) {
+	const idle = 20 * time.Millisecond
+	m := NewSessionManager(idle, 1)
+	files := map[string]model.FileDto{"f1": {Id: "f1", FileName: "a.bin", Size: 100}}
+	sessionId, err := m.Create(model.RegisterDto{Alias: "test"}, files, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 接收方确认的时间超过空闲超时
+	time.Sleep(2 * idle)
+	m.Expire()
+	if _, err := m.Accept(sessionId, nil, t.TempDir()); err != nil {
+		t.Fatalf("等待确认的会话被清理: %v", err)
+	}
+
+	// 接受之后重新按空闲超时计算
+	m.Expire()
+	if _, err := m.UploadOffset(sessionId, "f1", "x"); errors.Is(err, ErrSessionNotFound) {
+		t.Error("刚接受的会话不应立即被清理")
+	}
+	time.Sleep(2 * idle)
+	m.Expire()
+	if _, err := m.UploadOffset(sessionId, "f1", "x"); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("接受后空闲超时的会话应被清理: %v", err)
+	}
+}
+
+func TestSuspendUploadWithoutResume(t *testing.T) {
+	m := NewSessionManager(time.Minute, 1)
+	files := map[string]model.FileDto{"f1": {Id: "f1", FileName: "a.bin", Size: 100}}
+	sessionId, err := m.Create(model.RegisterDto{Alias: "stock"}, files, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := m.Accept(sessionId, nil, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.BeginUpload(sessionId, "f1", tokens["f1"], 0); err != nil {
+		t.Fatal(err)
+	}
+	partial := filepath.Join(t.TempDir(), "a.bin.part")
+	if err := os.WriteFile(partial, make([]byte, 40), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// 不支持续传的发送方断开连接：会话立即取消，释放唯一的会话名额
+	m.SuspendUpload(sessionId, "f1", partial, 40)
+	if _, err := os.Stat(partial); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("临时文件应被删除: %v", err)
+	}
+	if _, err := m.UploadOffset(sessionId, "f1", tokens["f1"]); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("会话应被取消: %v", err)
+	}
+	if _, err := m.Create(model.RegisterDto{Alias: "next"}, files, false); err != nil {
+		t.Errorf("会话名额未释放: %v", err)
+	}
+}
+
+func TestTakeoverMarksResumable(t *testing.T) {
+	m := NewSessionManager(time.Minute, 1)
+	files := map[string]model.FileDto{"f1": {Id: "f1", FileName: "a.bin", Size: 100}}
+	sessionId, err := m.Create(model.RegisterDto{Alias: "test"}, files, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := m.Accept(sessionId, nil, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ticket, err := m.BeginUpload(sessionId, "f1", tokens["f1"], 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	partial := filepath.Join(t.TempDir(), "a.bin.part")
+	if err := os.WriteFile(partial, make([]byte, 40), 0600); err != nil {
+		t.Fatal(err)
+	}
+	go fakeUpload(m, ticket, partial, 40)
+
+	// 发送方用同一 Token 重新连接，被接管的上传仍应保留数据
+	if offset, err := m.UploadOffset(sessionId, "f1", tokens["f1"]); err != nil || offset != 40 {
+		t.Fatalf("UploadOffset = %d, %v; 应为 40", offset, err)
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"chrelyonly-localsend-go/model"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrShareInactive           = errors.New("未开启下载模式")
+	ErrDownloadSessionNotFound = errors.New("下载会话不存在或已过期")
+	ErrDownloadRejected        = errors.New("下载请求被拒绝")
+)
+
+// downloadSession 一次下载会话，由 prepare-download 或网页创建
+type downloadSession struct {
+	id        string
+	remoteIP  string          // 创建会话的地址，只有该地址可以用会话下载
+	files     map[string]bool // 允许下载的文件，为 nil 时允许全部
+	updatedAt time.Time
+}
+
+// allows 判断会话是否允许下载该文件
+func (sess *downloadSession) allows(fileId string) bool {
+	return sess.files == nil || sess.files[fileId]
+}
+
+// Share 下载模式下对外发布的一组文件 (LocalSend 的反向传输)
+// 对方 (LocalSend 客户端或浏览器) 先请求 prepare-download 或网页获得会话和文件列表，
+// 再逐个请求 download 拉取文件。文件列表在创建时确定，之后不会变化；
+// 每个会话可以只允许下载其中一部分文件；下载会话空闲超过 idleTimeout 后失效。
+type Share struct {
+	files       map[string]localFile // key: fileId
+	idleTimeout time.Duration
+
+	mu       sync.Mutex
+	sessions map[string]*downloadSession
+}
+
+// NewShare 发布 paths 中的文件和目录，目录会被递归展开
+func NewShare(paths []string, idleTimeout time.Duration) (*Share, error) {
+	files, err := collectFiles(paths)
+	if err != nil {
+		return nil, err
+	}
+	sh := &Share{
+		files:       make(map[string]localFile, len(files)),
+		idleTimeout: idleTimeout,
+		sessions:    make(map[string]*downloadSession),
+	}
+	for _, f := range files {
+		sh.files[f.dto.Id] = f
+	}
+	return sh, nil
+}
+
+// Files 返回发布的文件，按文件名排序
+func (sh *Share) Files() []model.FileDto {
+	return sh.filter(nil)
+}
+
+// SessionFiles 返回会话允许下载的文件，按文件名排序
+func (sh *Share) SessionFiles(sessionId string) []model.FileDto {
+	sh.mu.Lock()
+	sess, ok := sh.sessions[sessionId]
+	sh.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	return sh.filter(sess)
+}
+
+// filter 返回 sess 允许下载的文件，sess 为 nil 时返回全部
+func (sh *Share) filter(sess *downloadSession) []model.FileDto {
+	dtos := make([]model.FileDto, 0, len(sh.files))
+	for id, f := range sh.files {
+		if sess == nil || sess.allows(id) {
+			dtos = append(dtos, f.dto)
+		}
+	}
+	sort.Slice(dtos, func(i, j int) bool {
+		return dtos[i].FileName < dtos[j].FileName
+	})
+	return dtos
+}
+
+// Resume 判断 sessionId 是否为 remoteIP 仍然有效的下载会话，有效时刷新其活动时间
+// 已被接受的访问者再次请求 (如刷新网页) 时沿用会话，无需再次确认
+func (sh *Share) Resume(sessionId, remoteIP string) bool {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	now := time.Now()
+	sh.expireLocked(now)
+	sess, ok := sh.sessions[sessionId]
+	if !ok || sess.remoteIP != remoteIP {
+		return false
+	}
+	sess.updatedAt = now
+	return true
+}
+
+// Open 为已被接受的 remoteIP 创建下载会话，返回会话 ID
+// fileIds 为 nil 时允许下载全部文件，否则只允许其中列出的文件
+func (sh *Share) Open(remoteIP string, fileIds []string) string {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	now := time.Now()
+	sh.expireLocked(now)
+	sess := &downloadSession{
+		id:        uuid.New().String(),
+		remoteIP:  remoteIP,
+		updatedAt: now,
+	}
+	if fileIds != nil {
+		sess.files = make(map[string]bool, len(fileIds))
+		for _, id := range fileIds {
+			sess.files[id] = true
+		}
+	}
+	sh.sessions[sess.id] = sess
+	return sess.id
+}
+
+// request 生成交给 AcceptHandler 决定的下载请求
+func (sh *Share) request(visitor model.RegisterDto, remoteAddr string) TransferRequest {
+	files := make(map[string]model.FileDto, len(sh.files))
+	for id, f := range sh.files {
+		files[id] = f.dto
+	}
+	return TransferRequest{
+		Sender:     visitor,
+		RemoteAddr: remoteAddr,
+		Files:      files,
+		Download:   true,
+	}
+}
+
+// Lookup 校验下载会话，返回要下载的文件
+func (sh *Share) Lookup(sessionId, fileId, remoteIP string) (localFile, error) {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	now := time.Now()
+	sh.expireLocked(now)
+	sess, ok := sh.sessions[sessionId]
+	if !ok || sess.remoteIP != remoteIP {
+		return localFile{}, ErrDownloadSessionNotFound
+	}
+	f, ok := sh.files[fileId]
+	if !ok || !sess.allows(fileId) {
+		return localFile{}, ErrFileNotFound
+	}
+	sess.updatedAt = now
+	return f, nil
+}
+
+// expireLocked 移除空闲超时的下载会话，调用方需持有锁
+func (sh *Share) expireLocked(now time.Time) {
+	deadline := now.Add(-sh.idleTimeout)
+	for id, sess := range sh.sessions {
+		if sess.updatedAt.Before(deadline) {
+			delete(sh.sessions, id)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// sizeUnits 大小单位，按 1024 进制，从大到小排列
+var sizeUnits = []struct {
+	suffix string
+	factor int64
+}{
+	{"T", 1 << 40},
+	{"G", 1 << 30},
+	{"M", 1 << 20},
+	{"K", 1 << 10},
+}
+
+// ParseSize 解析带单位的大小，如 "512K"、"4G"、"1.5GB"、"2GiB"、"1048576"
+// 空字符串和 "0" 表示不限制，返回 0
+func ParseSize(s string) (int64, error) {
+	v := strings.ToUpper(strings.TrimSpace(s))
+	if v == "" {
+		return 0, nil
+	}
+
+	if n, ok := strings.CutSuffix(v, "IB"); ok {
+		v = n
+	} else {
+		v = strings.TrimSuffix(v, "B")
+	}
+	factor := int64(1)
+	for _, u := range sizeUnits {
+		if n, ok := strings.CutSuffix(v, u.suffix); ok {
+			v, factor = n, u.factor
+			break
+		}
+	}
+
+	f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+	if err != nil || f < 0 || math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, fmt.Errorf("无效的大小 %q，示例: 512K、100M、4G", s)
+	}
+	// float64(math.MaxInt64) 即 2^63，等于它时转换同样会溢出
+	n := f * float64(factor)
+	if n >= float64(math.MaxInt64) {
+		return 0, fmt.Errorf("大小 %q 超出范围，最大为 %s", s, FormatSize(math.MaxInt64))
+	}
+	return int64(n), nil
+}
+
+// addSize 累加文件大小，size 为负数或结果溢出 int64 时返回 false
+func addSize(total, size int64) (int64, bool) {
+	if size < 0 || size > math.MaxInt64-total {
+		return total, false
+	}
+	return total + size, true
+}
+
+// FormatSize 将字节数格式化为易读的形式，如 1.5 GiB
+func FormatSize(n int64) string {
+	for _, u := range sizeUnits {
+		if n >= u.factor {
+			return fmt.Sprintf("%.1f %siB", float64(n)/float64(u.factor), u.suffix)
+		}
+	}
+	return fmt.Sprintf("%d B", n)
+}
@@ -0,0 +1,41 @@
+package main
+
+import "testing"
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+	}{
+		{"", 0},
+		{"0", 0},
+		{"1048576", 1 << 20},
+		{"512K", 512 << 10},
+		{"512kb", 512 << 10},
+		{"100M", 100 << 20},
+		{"1.5GB", 3 << 29},
+		{"2GiB", 2 << 30},
+		{" 4 G ", 4 << 30},
+		{"1T", 1 << 40},
+		{"1e3", 1000},
+		{"8388607T", 8388607 << 40},
+	}
+	for _, tt := range tests {
+		got, err := ParseSize(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseSize(%q) = %d, %v; 应为 %d", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestParseSizeInvalid(t *testing.T) {
+	for _, in := range []string{
+		"abc", "-1", "-1G", "G", "1X",
+		"inf", "+Inf", "-inf", "NaN", "nanK",
+		"1e30", "1e400", "9223372036854775808", "8388608T", "1e19B",
+	} {
+		if got, err := ParseSize(in); err == nil {
+			t.Errorf("ParseSize(%q) = %d; 应返回错误", in, got)
+		}
+	}
+}
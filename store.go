@@ -0,0 +1,189 @@
+package main
+
+import (
+	"chrelyonly-localsend-go/model"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	ErrUploadTooLarge   = errors.New("上传数据超过声明的文件大小")
+	ErrUploadIncomplete = errors.New("上传数据少于声明的文件大小")
+	ErrHashMismatch     = errors.New("文件哈希校验失败")
+	// ErrUploadInterrupted 读取上传数据时连接中断，临时文件被保留用于续传
+	ErrUploadInterrupted = errors.New("上传中断")
+)
+
+// storeResult 保存成功后的结果
+type storeResult struct {
+	Path    string // 最终保存路径；哈希不符被隔离时为隔离区中的路径
+	Written int64  // 写入的字节数
+	Hash    string // 接收数据的 SHA-256，十六进制
+	Partial string // 上传中断时保留的临时文件
+}
+
+// storeUpload 接收上传数据并原子地保存到 savePath 所在目录
+// 数据先写入同目录下的隐藏临时文件 (.文件名.*.part)，边写边计算 SHA-256，
+// fsync 并校验大小和哈希后，再按重名策略放到最终位置。
+// partial 非空时为续传：在已有的 offset 字节之后追加，哈希从已有数据开始计算。
+// 读取请求体时连接中断会返回 ErrUploadInterrupted，并在 Partial 中保留临时文件以便续传；
+// ctx 以 ErrUploadSuperseded 结束 (被接管) 同样视为中断；
+// 其他错误或 ctx 被取消（会话取消）时删除临时文件，监听下载目录的程序只会看到完整的文件。
+// 哈希不符时，quarantineDir 非空则把文件移入隔离目录，否则直接删除；两种情况都返回 ErrHashMismatch。
+func storeUpload(ctx context.Context, body io.Reader, savePath string, file model.FileDto, partial string, offset int64, strategy ConflictStrategy, quarantineDir string) (storeResult, error) {
+	var res storeResult
+	tmp, err := openPartial(savePath, partial, offset)
+	if err != nil {
+		return res, err
+	}
+	tmpPath := tmp.Name()
+	committed := false
+	defer func() {
+		if !committed {
+			tmp.Close()
+			os.Remove(tmpPath)
+		}
+	}()
+
+	// 续传时先把已有数据计入哈希
+	hasher := sha256.New()
+	if offset > 0 {
+		if _, err := io.Copy(hasher, io.NewSectionReader(tmp, 0, offset)); err != nil {
+			return res, fmt.Errorf("读取已接收的数据失败: %w", err)
+		}
+	}
+
+	// io.Copy 会高效地将 Request Body 流复制到文件
+	// 最多读取 剩余大小+1 字节：多出的 1 字节用于发现超长的上传，而不会无限制地写盘
+	reader := &trackingReader{r: &contextReader{ctx: ctx, r: body}}
+	limited := io.LimitReader(reader, file.Size-offset+1)
+	n, err := io.Copy(io.MultiWriter(tmp, hasher), limited)
+	res.Written = offset + n
+	if err != nil {
+		// 连接中断或被新请求接管（而非会话取消或写盘失败）时保留已写入的数据，等待续传
+		interrupted := ctx.Err() == nil || errors.Is(context.Cause(ctx), ErrUploadSuperseded)
+		if reader.err != nil && interrupted && res.Written <= file.Size && tmp.Sync() == nil {
+			tmp.Close()
+			committed = true
+			res.Partial = tmpPath
+			return res, fmt.Errorf("%w: 已接收 %d/%d 字节: %v", ErrUploadInterrupted, res.Written, file.Size, err)
+		}
+		return res, fmt.Errorf("写入文件失败: %w", err)
+	}
+	if res.Written > file.Size {
+		return res, fmt.Errorf("%w: 声明 %d 字节", ErrUploadTooLarge, file.Size)
+	}
+	if res.Written < file.Size {
+		return res, fmt.Errorf("%w: 实际 %d 字节，声明 %d 字节", ErrUploadIncomplete, res.Written, file.Size)
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		return res, fmt.Errorf("设置文件权限失败: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		return res, fmt.Errorf("同步文件失败: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return res, fmt.Errorf("关闭文件失败: %w", err)
+	}
+	if err := ctx.Err(); err != nil {
+		return res, err
+	}
+
+	res.Hash = hex.EncodeToString(hasher.Sum(nil))
+	if file.Hash != "" && !strings.EqualFold(file.Hash, res.Hash) {
+		mismatch := fmt.Errorf("%w: 声明 %s，实际 %s", ErrHashMismatch, file.Hash, res.Hash)
+		if quarantineDir == "" {
+			return res, mismatch
+		}
+		if err := os.MkdirAll(quarantineDir, 0700); err != nil {
+			return res, fmt.Errorf("%w (隔离失败: %v)", mismatch, err)
+		}
+		res.Path, err = placeFile(tmpPath, filepath.Join(quarantineDir, filepath.Base(savePath)), ConflictRename)
+		if err != nil {
+			return res, fmt.Errorf("%w (隔离失败: %v)", mismatch, err)
+		}
+		committed = true
+		return res, mismatch
+	}
+
+	res.Path, err = placeFile(tmpPath, savePath, strategy)
+	if err != nil {
+		return res, err
+	}
+	committed = true
+	return res, nil
+}
+
+// openPartial 打开用于接收的临时文件：续传时打开已有文件并截断到 offset，否则新建
+func openPartial(savePath, partial string, offset int64) (*os.File, error) {
+	if partial == "" {
+		tmp, err := os.CreateTemp(filepath.Dir(savePath), "."+filepath.Base(savePath)+".*.part")
+		if err != nil {
+			return nil, fmt.Errorf("创建临时文件失败: %w", err)
+		}
+		return tmp, nil
+	}
+
+	tmp, err := os.OpenFile(partial, os.O_RDWR, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("打开续传临时文件失败: %w", err)
+	}
+	if err := tmp.Truncate(offset); err != nil {
+		tmp.Close()
+		return nil, fmt.Errorf("截断续传临时文件失败: %w", err)
+	}
+	if _, err := tmp.Seek(offset, io.SeekStart); err != nil {
+		tmp.Close()
+		return nil, fmt.Errorf("定位续传临时文件失败: %w", err)
+	}
+	return tmp, nil
+}
+
+// trackingReader 记录读取时遇到的错误，用于区分连接中断和写盘失败
+type trackingReader struct {
+	r   io.Reader
+	err error
+}
+
+func (t *trackingReader) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	if err != nil && err != io.EOF {
+		t.err = err
+	}
+	return n, err
+}
+
+// contextReader 在 ctx 结束后让读取立即失败，用于中止被取消会话的上传
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c *contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+// hashFile 计算文件的 SHA-256，十六进制表示
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"chrelyonly-localsend-go/model"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrTextRateLimited 消息处理命令执行得过于频繁
+var ErrTextRateLimited = errors.New("消息过于频繁，请稍后再试")
+
+// TextMessage 收到的文本消息
+// LocalSend 发送文本时只提供一个 text/plain 文件，内容放在 preview 中，不会上传文件数据。
+type TextMessage struct {
+	Time       time.Time
+	From       model.RegisterDto // 发送方设备信息
+	RemoteAddr string            // 发送方地址 (ip:port)
+	Text       string
+}
+
+// TextSink 处理收到的文本消息
+type TextSink interface {
+	Deliver(msg TextMessage) error
+}
+
+// TextSinkFunc 让普通函数实现 TextSink
+type TextSinkFunc func(msg TextMessage) error
+
+func (f TextSinkFunc) Deliver(msg TextMessage) error {
+	return f(msg)
+}
+
+// textMessage 判断 prepare-upload 请求是否为文本消息：只有一个带 preview 的 text/plain 文件
+func textMessage(req model.PrepareUploadRequestDto) (string, bool) {
+	if len(req.Files) != 1 {
+		return "", false
+	}
+	for _, f := range req.Files {
+		mediaType, _, _ := strings.Cut(f.FileType, ";")
+		if strings.TrimSpace(mediaType) == "text/plain" && f.Preview != "" {
+			return f.Preview, true
+		}
+	}
+	return "", false
+}
+
+// ParseTextSink 解析文本消息的处理方式
+//   - stdout: 打印到标准输出 (默认)
+//   - file:路径: 追加到文件
+//   - hook:命令: 通过 shell 执行命令，消息内容从标准输入传入，
+//     发送方信息通过环境变量 STRAWBERRY_FROM、STRAWBERRY_FINGERPRINT、STRAWBERRY_ADDR 传入
+func ParseTextSink(spec string) (TextSink, error) {
+	kind, arg, _ := strings.Cut(spec, ":")
+	switch kind {
+	case "", "stdout":
+		return NewWriterTextSink(os.Stdout), nil
+	case "file":
+		if arg == "" {
+			return nil, fmt.Errorf("file 需要指定路径，如 file:messages.txt")
+		}
+		return NewFileTextSink(arg), nil
+	case "hook":
+		if arg == "" {
+			return nil, fmt.Errorf("hook 需要指定命令，如 hook:notify-send 新消息")
+		}
+		return NewHookTextSink(arg, TextHookTimeout, TextHookBurst, TextHookInterval), nil
+	}
+	return nil, fmt.Errorf("无效的文本消息处理方式 %q，可选: stdout、file:路径、hook:命令", spec)
+}
+
+// textPreview 截断过长的消息，用于确认提示
+func textPreview(text string) string {
+	const limit = 200
+	runes := []rune(text)
+	if len(runes) <= limit {
+		return text
+	}
+	return string(runes[:limit]) + "..."
+}
+
+// formatTextMessage 文本消息的可读格式
+func formatTextMessage(msg TextMessage) string {
+	return fmt.Sprintf("[%s] %s (%s):\n%s\n", msg.Time.Format("2006-01-02 15:04:05"), msg.From.Alias, remoteIP(msg.RemoteAddr), msg.Text)
+}
+
+// writerTextSink 把消息写到 io.Writer
+type writerTextSink struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+// NewWriterTextSink 创建把消息写到 out 的处理器
+func NewWriterTextSink(out io.Writer) TextSink {
+	return &writerTextSink{out: out}
+}
+
+func (s *writerTextSink) Deliver(msg TextMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := fmt.Fprintf(s.out, "\n[消息] %s", formatTextMessage(msg))
+	return err
+}
+
+// fileTextSink 把消息追加到文件
+type fileTextSink struct {
+	mu   sync.Mutex
+	path string
+}
+
+// NewFileTextSink 创建把消息追加到 path 的处理器，每条消息之后空一行
+func NewFileTextSink(path string) TextSink {
+	return &fileTextSink{path: path}
+}
+
+func (s *fileTextSink) Deliver(msg TextMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("打开消息文件失败: %v", err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(formatTextMessage(msg) + "\n"); err != nil {
+		return fmt.Errorf("写入消息文件失败: %v", err)
+	}
+	return nil
+}
+
+// hookTextSink 为每条消息执行一次命令
+type hookTextSink struct {
+	command string
+	timeout time.Duration
+	limiter *rateLimiter
+}
+
+// NewHookTextSink 创建执行 command 的处理器，命令运行超过 timeout 会被终止
+// 命令最多连续执行 burst 次，之后每 interval 才允许执行一次，超出时返回 ErrTextRateLimited，
+// 避免局域网内的设备通过大量消息反复触发命令。
+func NewHookTextSink(command string, timeout time.Duration, burst int, interval time.Duration) TextSink {
+	return &hookTextSink{command: command, timeout: timeout, limiter: newRateLimiter(burst, interval)}
+}
+
+func (s *hookTextSink) Deliver(msg TextMessage) error {
+	if !s.limiter.Allow() {
+		return ErrTextRateLimited
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	// 消息内容只通过标准输入传递，不会拼接进命令行
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", s.command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", s.command)
+	}
+	cmd.Stdin = strings.NewReader(msg.Text)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(),
+		"STRAWBERRY_FROM="+msg.From.Alias,
+		"STRAWBERRY_FINGERPRINT="+msg.From.Fingerprint,
+		"STRAWBERRY_ADDR="+remoteIP(msg.RemoteAddr).String(),
+	)
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("执行消息处理命令失败: %v", err)
+	}
+	return nil
+}
+
+// rateLimiter 令牌桶限流：最多积攒 burst 个令牌，每 interval 补充一个
+type rateLimiter struct {
+	mu       sync.Mutex
+	burst    int
+	interval time.Duration
+	tokens   int
+	last     time.Time // 上次补充令牌的时间
+}
+
+func newRateLimiter(burst int, interval time.Duration) *rateLimiter {
+	return &rateLimiter{burst: burst, interval: interval, tokens: burst, last: time.Now()}
+}
+
+// Allow 取走一个令牌，没有令牌时返回 false
+func (l *rateLimiter) Allow() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if n := int(now.Sub(l.last) / l.interval); n > 0 {
+		l.tokens = min(l.burst, l.tokens+n)
+		l.last = l.last.Add(time.Duration(n) * l.interval)
+	}
+	if l.tokens == 0 {
+		return false
+	}
+	l.tokens--
+	return true
+}
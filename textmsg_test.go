@@ -0,0 +1,91 @@
+package main
+
+import (
+	"chrelyonly-localsend-go/model"
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// postText 向 s 的 prepare-upload 发送一条文本消息，返回状态码
+func postText(t *testing.T, s *FileServer, text string) int {
+	t.Helper()
+	return postPrepareUpload(t, s, map[string]model.FileDto{
+		"m": {Id: "m", FileName: "message.txt", Size: int64(len(text)), FileType: "text/plain", Preview: text},
+	})
+}
+
+func TestTextMessageAcceptHandler(t *testing.T) {
+	var delivered []string
+	var asked []TransferRequest
+	accept := true
+
+	s := NewFileServer(0, "test", "fp", "test", "", "", 1)
+	s.SetTextSink(TextSinkFunc(func(msg TextMessage) error {
+		delivered = append(delivered, msg.Text)
+		return nil
+	}))
+	s.SetAcceptHandler(AcceptHandlerFunc(func(ctx context.Context, req TransferRequest) (Decision, error) {
+		asked = append(asked, req)
+		return Decision{Accept: accept}, nil
+	}), time.Second)
+
+	if code := postText(t, s, "hello"); code != http.StatusNoContent {
+		t.Fatalf("接受时状态码为 %d，应为 204", code)
+	}
+	accept = false
+	if code := postText(t, s, "spam"); code != http.StatusForbidden {
+		t.Fatalf("拒绝时状态码为 %d，应为 403", code)
+	}
+
+	if len(asked) != 2 || asked[0].Text != "hello" || asked[0].Sender.Fingerprint != "abc" {
+		t.Errorf("确认器收到的请求不正确: %+v", asked)
+	}
+	if len(delivered) != 1 || delivered[0] != "hello" {
+		t.Errorf("投递的消息为 %q，应只有 hello", delivered)
+	}
+}
+
+func TestTextMessageRateLimited(t *testing.T) {
+	s := NewFileServer(0, "test", "fp", "test", "", "", 1)
+	// "exit 0" 在 sh 和 cmd 中都能执行
+	s.SetTextSink(NewHookTextSink("exit 0", TextHookTimeout, 2, time.Hour))
+
+	for i, want := range []int{http.StatusNoContent, http.StatusNoContent, http.StatusTooManyRequests} {
+		if code := postText(t, s, "hi"); code != want {
+			t.Errorf("第 %d 条消息的状态码为 %d，应为 %d", i+1, code, want)
+		}
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	const interval = 20 * time.Millisecond
+	l := newRateLimiter(3, interval)
+	for i := 0; i < 3; i++ {
+		if !l.Allow() {
+			t.Fatalf("第 %d 次应当允许", i+1)
+		}
+	}
+	if l.Allow() {
+		t.Fatal("令牌用完后应当拒绝")
+	}
+
+	time.Sleep(interval + interval/2)
+	if !l.Allow() {
+		t.Fatal("经过一个间隔后应补充一个令牌")
+	}
+	if l.Allow() {
+		t.Fatal("只应补充一个令牌")
+	}
+
+	// 长时间空闲后最多积攒 burst 个令牌
+	time.Sleep(10 * interval)
+	allowed := 0
+	for l.Allow() {
+		allowed++
+	}
+	if allowed != 3 {
+		t.Errorf("空闲后允许了 %d 次，应为 3", allowed)
+	}
+}
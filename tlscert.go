@@ -0,0 +1,84 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"time"
+)
+
+// CertValidity 自签名证书有效期
+// LocalSend 不校验证书链和有效期，只比对指纹，所以这里给一个足够长的期限
+const CertValidity = 10 * 365 * 24 * time.Hour
+
+// generateCertificate 生成自签名 ECDSA 证书，并以 PEM 格式写入 certFile 和 keyFile
+func generateCertificate(certFile, keyFile, commonName string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("生成私钥失败: %v", err)
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return fmt.Errorf("生成证书序列号失败: %v", err)
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(CertValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return fmt.Errorf("生成证书失败: %v", err)
+	}
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("序列化私钥失败: %v", err)
+	}
+
+	// 先写私钥再写证书：加载时以证书是否存在判断密钥材料是否完整
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer})
+	if err := writeFileAtomic(keyFile, keyPem, 0600); err != nil {
+		return fmt.Errorf("保存私钥失败: %v", err)
+	}
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := writeFileAtomic(certFile, certPem, 0644); err != nil {
+		return fmt.Errorf("保存证书失败: %v", err)
+	}
+	return nil
+}
+
+// certFileFingerprint 读取 PEM 证书文件并计算其指纹
+func certFileFingerprint(certFile string) (string, error) {
+	data, err := os.ReadFile(certFile)
+	if err != nil {
+		return "", fmt.Errorf("读取证书失败: %v", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return "", fmt.Errorf("证书文件格式错误: %s", certFile)
+	}
+	return CertFingerprint(block.Bytes), nil
+}
+
+// CertFingerprint 计算证书指纹：DER 编码证书的 SHA-256，十六进制表示
+// 与 LocalSend 一致，HTTPS 模式下设备指纹即证书指纹，对端可据此校验身份
+func CertFingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	trustedPeersFileName = "trusted_peers.json"
+	// knownPeersFileName 旧版以别名为 key 的信任库，加载时迁移
+	knownPeersFileName = "known_peers.json"
+)
+
+// ErrFingerprintMismatch 对端证书指纹与预期不符，可能存在中间人
+var ErrFingerprintMismatch = errors.New("设备指纹不匹配")
+
+// KnownPeer 是信任库中的一台设备
+type KnownPeer struct {
+	Alias     string    `json:"alias"`     // 最近一次看到的别名，仅用于显示
+	FirstSeen time.Time `json:"firstSeen"` // 首次信任时间
+	LastSeen  time.Time `json:"lastSeen"`  // 最近一次验证通过的时间
+}
+
+// trustFile 信任库文件的内容
+type trustFile struct {
+	Peers   map[string]*KnownPeer `json:"peers"`   // key: 证书指纹
+	Targets map[string]string     `json:"targets"` // key: 用户指定的目标 (见 AddrTarget、AliasTarget)，value: 证书指纹
+}
+
+// TrustStore 已知设备信任库，采用首次使用即信任 (TOFU) 策略
+// 设备以证书指纹标识；别名由对方自己决定且可能重复 (如未改名的默认别名)，只作为显示信息。
+// 用户第一次通过某个目标 (IP:端口或别名) 连接时，记录该目标对应的指纹；
+// 之后同一目标的指纹必须一致，否则视为冒充并拒绝发送。
+// 按指纹前缀指定目标时，指纹本身已由 TLS 证书校验，无需记录。
+type TrustStore struct {
+	mu   sync.Mutex
+	path string
+	data trustFile
+}
+
+// AddrTarget 按 IP 和端口指定目标时的信任库 key
+func AddrTarget(ip string, port int) string {
+	return net.JoinHostPort(ip, strconv.Itoa(port))
+}
+
+// AliasTarget 按别名指定目标时的信任库 key，别名不区分大小写
+func AliasTarget(alias string) string {
+	return "alias:" + strings.ToLower(alias)
+}
+
+// LoadTrustStore 从状态目录加载信任库，文件不存在时返回空库
+// 只有旧版信任库时，把其中的记录迁移为按别名指定的目标。
+func LoadTrustStore(dir string) (*TrustStore, error) {
+	ts := &TrustStore{
+		path: filepath.Join(dir, trustedPeersFileName),
+		data: trustFile{
+			Peers:   make(map[string]*KnownPeer),
+			Targets: make(map[string]string),
+		},
+	}
+	data, err := os.ReadFile(ts.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return ts, ts.migrate(filepath.Join(dir, knownPeersFileName))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取信任库失败: %v", err)
+	}
+	if err := json.Unmarshal(data, &ts.data); err != nil {
+		return nil, fmt.Errorf("解析信任库失败 (%s): %v", ts.path, err)
+	}
+	if ts.data.Peers == nil {
+		ts.data.Peers = make(map[string]*KnownPeer)
+	}
+	if ts.data.Targets == nil {
+		ts.data.Targets = make(map[string]string)
+	}
+	return ts, nil
+}
+
+// migrate 导入旧版以别名为 key 的信任库，文件不存在时什么也不做
+func (ts *TrustStore) migrate(oldPath string) error {
+	data, err := os.ReadFile(oldPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("读取旧版信任库失败: %v", err)
+	}
+	var old map[string]struct {
+		Fingerprint string    `json:"fingerprint"`
+		FirstSeen   time.Time `json:"firstSeen"`
+		LastSeen    time.Time `json:"lastSeen"`
+	}
+	if err := json.Unmarshal(data, &old); err != nil {
+		return fmt.Errorf("解析旧版信任库失败 (%s): %v", oldPath, err)
+	}
+	for alias, p := range old {
+		ts.data.Targets[AliasTarget(alias)] = p.Fingerprint
+		ts.data.Peers[p.Fingerprint] = &KnownPeer{Alias: alias, FirstSeen: p.FirstSeen, LastSeen: p.LastSeen}
+	}
+	return nil
+}
+
+// Verify 校验用户指定的目标与设备指纹的对应关系，alias 为对方当前的别名
+// target 为空 (按指纹指定目标) 时只记录设备；目标第一次出现时记录并信任；
+// 已记录的目标指纹变化时返回 ErrFingerprintMismatch，与对方现在使用什么别名无关。
+func (ts *TrustStore) Verify(target, alias, fingerprint string) error {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if pinned, ok := ts.data.Targets[target]; ok && target != "" && pinned != fingerprint {
+		previous := ""
+		if p := ts.data.Peers[pinned]; p != nil {
+			previous = fmt.Sprintf(" (%s)", p.Alias)
+		}
+		return fmt.Errorf("%w: 目标 %s 之前的设备指纹为 %s%s，现在为 %s (%s)。"+
+			"如果对方确实重置了身份或更换了地址，请从 %s 的 targets 中删除该条记录后重试",
+			ErrFingerprintMismatch, target, pinned, previous, fingerprint, alias, ts.path)
+	}
+
+	now := time.Now()
+	peer, ok := ts.data.Peers[fingerprint]
+	if !ok {
+		fmt.Printf("[信任库] 首次连接设备 %q，记录指纹 %s\n", alias, fingerprint)
+		peer = &KnownPeer{FirstSeen: now}
+		ts.data.Peers[fingerprint] = peer
+	}
+	peer.Alias = alias
+	peer.LastSeen = now
+	if target != "" {
+		ts.data.Targets[target] = fingerprint
+	}
+	return ts.save()
+}
+
+// save 持久化信任库，调用方需持有锁
+func (ts *TrustStore) save() error {
+	data, err := json.MarshalIndent(ts.data, "", "  ")
+	if err != nil {
+		return fmt.Errorf("序列化信任库失败: %v", err)
+	}
+	if err := writeFileAtomic(ts.path, data, 0600); err != nil {
+		return fmt.Errorf("保存信任库失败: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"chrelyonly-localsend-go/model"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTrustStoreSharedAlias(t *testing.T) {
+	dir := t.TempDir()
+	ts, err := LoadTrustStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 两台未改名的设备使用相同的默认别名，但地址和指纹不同
+	if err := ts.Verify(AddrTarget("192.168.1.2", DefaultPort), DefaultAlias, "fp-a"); err != nil {
+		t.Fatalf("第一台设备: %v", err)
+	}
+	if err := ts.Verify(AddrTarget("192.168.1.3", DefaultPort), DefaultAlias, "fp-b"); err != nil {
+		t.Fatalf("别名相同、指纹不同的第二台设备应当被信任: %v", err)
+	}
+	// 按指纹前缀指定目标时不固定任何目标
+	if err := ts.Verify("", DefaultAlias, "fp-c"); err != nil {
+		t.Fatalf("按指纹指定的设备: %v", err)
+	}
+
+	// 同一地址换了证书，即使改用新别名也要拒绝
+	err = ts.Verify(AddrTarget("192.168.1.2", DefaultPort), "新名字", "fp-evil")
+	if !errors.Is(err, ErrFingerprintMismatch) {
+		t.Errorf("同一地址指纹变化时返回 %v，应为 ErrFingerprintMismatch", err)
+	}
+	// 按别名指定的目标同样固定
+	if err := ts.Verify(AliasTarget("Laptop"), "Laptop", "fp-a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.Verify(AliasTarget("laptop"), "laptop", "fp-evil"); !errors.Is(err, ErrFingerprintMismatch) {
+		t.Errorf("别名目标指纹变化时返回 %v，应为 ErrFingerprintMismatch", err)
+	}
+
+	// 重新加载后固定关系仍然有效
+	ts, err = LoadTrustStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.Verify(AddrTarget("192.168.1.3", DefaultPort), "renamed", "fp-b"); err != nil {
+		t.Errorf("重新加载后已信任的设备: %v", err)
+	}
+	if err := ts.Verify(AddrTarget("192.168.1.3", DefaultPort), DefaultAlias, "fp-a"); !errors.Is(err, ErrFingerprintMismatch) {
+		t.Errorf("重新加载后指纹变化时返回 %v，应为 ErrFingerprintMismatch", err)
+	}
+	if got := ts.data.Peers["fp-b"].Alias; got != "renamed" {
+		t.Errorf("设备别名为 %q，应更新为 renamed", got)
+	}
+}
+
+func TestTrustStoreMigrate(t *testing.T) {
+	dir := t.TempDir()
+	old := `{"Laptop": {"fingerprint": "fp-a", "firstSeen": "2024-01-01T00:00:00Z", "lastSeen": "2024-01-02T00:00:00Z"}}`
+	if err := os.WriteFile(filepath.Join(dir, knownPeersFileName), []byte(old), 0600); err != nil {
+		t.Fatal(err)
+	}
+	ts, err := LoadTrustStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.Verify(AliasTarget("laptop"), "Laptop", "fp-x"); !errors.Is(err, ErrFingerprintMismatch) {
+		t.Errorf("迁移后的别名目标指纹变化时返回 %v，应为 ErrFingerprintMismatch", err)
+	}
+	if err := ts.Verify(AddrTarget("10.0.0.2", DefaultPort), "Laptop", "fp-x"); err != nil {
+		t.Errorf("旧信任库不应限制同名的其他设备: %v", err)
+	}
+}
+
+func TestResolveTargetPin(t *testing.T) {
+	registry := NewPeerRegistry(PeerTTL)
+	registry.Upsert(model.MulticastDto{Fingerprint: "abcdef", Alias: "Laptop", Port: DefaultPort}, "10.0.0.2")
+
+	for target, want := range map[string]string{
+		"10.0.0.9":      AddrTarget("10.0.0.9", DefaultPort),
+		"10.0.0.9:1234": AddrTarget("10.0.0.9", 1234),
+		"laptop":        AliasTarget("Laptop"),
+		"abc":           "",
+	} {
+		peer, err := ResolveTarget(registry, target, 0)
+		if err != nil {
+			t.Fatalf("ResolveTarget(%q): %v", target, err)
+		}
+		if peer.Target != want {
+			t.Errorf("ResolveTarget(%q).Target = %q，应为 %q", target, peer.Target, want)
+		}
+	}
+}
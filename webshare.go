@@ -0,0 +1,185 @@
+package main
+
+import (
+	"chrelyonly-localsend-go/model"
+	"errors"
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// webShareCookie 浏览器保存下载会话 ID 的 Cookie，刷新页面时无需重新输入 PIN 和等待确认
+const webShareCookie = "strawberry_share"
+
+// webSharePage 网页共享的页面数据
+type webSharePage struct {
+	Alias   string
+	Message string         // 提示信息，非空时只显示提示
+	NeedPin bool           // 显示 PIN 输入框
+	PinErr  string         // PIN 校验失败的原因
+	Files   []webShareFile // 可下载的文件
+}
+
+// webShareFile 页面中的一个文件
+type webShareFile struct {
+	Name string
+	Size string
+	URL  string
+}
+
+var webShareTemplate = template.Must(template.New("share").Parse(`<!DOCTYPE html>
+<html lang="zh-CN">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Alias}} 的共享文件</title>
+<style>
+body { font-family: system-ui, sans-serif; max-width: 40em; margin: 0 auto; padding: 1em; color: #222; }
+h1 { font-size: 1.3em; }
+ul { list-style: none; padding: 0; }
+li { display: flex; justify-content: space-between; gap: 1em; padding: .7em 0; border-bottom: 1px solid #ddd; }
+a { color: #c0392b; word-break: break-all; }
+.size { color: #777; white-space: nowrap; }
+.error { color: #c0392b; }
+input, button { font-size: 1em; padding: .4em; }
+</style>
+</head>
+<body>
+<h1>{{.Alias}} 的共享文件</h1>
+{{if .Message}}
+<p>{{.Message}}</p>
+{{else if .NeedPin}}
+<form method="post">
+<p>请输入 PIN 以查看共享文件</p>
+{{if .PinErr}}<p class="error">{{.PinErr}}</p>{{end}}
+<input type="password" name="pin" inputmode="numeric" autofocus required>
+<button type="submit">确定</button>
+</form>
+{{else}}
+<ul>
+{{range .Files}}<li><a href="{{.URL}}" download="{{.Name}}">{{.Name}}</a><span class="size">{{.Size}}</span></li>
+{{else}}<li>没有可下载的文件</li>
+{{end}}
+</ul>
+{{end}}
+</body>
+</html>
+`))
+
+// handleWebShare GET/POST /
+// 网页共享：为没有 LocalSend 客户端的设备提供文件列表和下载链接。
+// 与 prepare-download 使用相同的 PIN 校验、确认器和下载会话，下载链接指向 v2 的 download 接口。
+// 设置了 PIN 时先显示 PIN 表单 (POST 提交，避免 PIN 出现在地址栏和历史记录中)；
+// 之后请求会阻塞到确认器做出决定，被接受后下载会话 ID 保存在 Cookie 中。
+func (s *FileServer) handleWebShare(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "方法不允许", http.StatusMethodNotAllowed)
+		return
+	}
+
+	page := webSharePage{Alias: s.alias}
+	share := s.share.Load()
+	if share == nil {
+		page.Message = "当前没有共享文件。"
+		s.renderWebShare(w, http.StatusNotFound, page)
+		return
+	}
+
+	// 已被接受的访问者刷新页面时直接显示文件列表
+	ip := remoteIP(r.RemoteAddr).String()
+	sessionId := ""
+	if c, err := r.Cookie(webShareCookie); err == nil && share.Resume(c.Value, ip) {
+		sessionId = c.Value
+	}
+
+	if sessionId == "" && s.pins.Enabled() {
+		pin := ""
+		if r.Method == http.MethodPost {
+			pin = r.PostFormValue("pin")
+		}
+		switch err := s.pins.Check(ip, pin); {
+		case errors.Is(err, ErrPinLocked):
+			fmt.Printf("[服务端] %s PIN 错误次数过多，已暂时锁定\n", ip)
+			page.Message = err.Error()
+			s.renderWebShare(w, http.StatusTooManyRequests, page)
+			return
+		case errors.Is(err, ErrPinInvalid):
+			page.NeedPin = true
+			page.PinErr = err.Error()
+			s.renderWebShare(w, http.StatusUnauthorized, page)
+			return
+		case err != nil:
+			page.NeedPin = true
+			s.renderWebShare(w, http.StatusUnauthorized, page)
+			return
+		}
+	}
+
+	if sessionId == "" {
+		visitor := model.RegisterDto{
+			Alias:       "浏览器",
+			DeviceModel: r.UserAgent(),
+			DeviceType:  model.DeviceTypeWeb,
+		}
+		var err error
+		sessionId, err = s.authorizeDownload(r, share, "", visitor)
+		if err != nil {
+			page.Message = "对方拒绝了你的下载请求。"
+			s.renderWebShare(w, http.StatusForbidden, page)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     webShareCookie,
+			Value:    sessionId,
+			Path:     "/",
+			HttpOnly: true,
+			Secure:   r.TLS != nil,
+			SameSite: http.SameSiteStrictMode,
+		})
+	}
+
+	for _, f := range share.SessionFiles(sessionId) {
+		q := url.Values{"sessionId": {sessionId}, "fileId": {f.Id}}
+		page.Files = append(page.Files, webShareFile{
+			Name: f.FileName,
+			Size: FormatSize(f.Size),
+			URL:  "/api/localsend/v2/download?" + q.Encode(),
+		})
+	}
+	s.renderWebShare(w, http.StatusOK, page)
+}
+
+// renderWebShare 输出共享页面
+func (s *FileServer) renderWebShare(w http.ResponseWriter, status int, page webSharePage) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := webShareTemplate.Execute(w, page); err != nil {
+		fmt.Printf("[服务端] 渲染共享页面失败: %v\n", err)
+	}
+}
+
+// webShareURLs 返回局域网内可访问共享页面的地址
+func webShareURLs(port int) []string {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil
+	}
+	var urls []string
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.IsLoopback() || ipNet.IP.To4() == nil {
+			continue
+		}
+		host := net.JoinHostPort(ipNet.IP.String(), strconv.Itoa(port))
+		urls = append(urls, fmt.Sprintf("%s://%s/", ProtocolTypeHttpStatus, host))
+	}
+	return urls
+}